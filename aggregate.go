@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/collector"
+	"igdb-database/db"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+)
+
+func runAggregate(ctx context.Context, args []string) int {
+	fs := newFlagSet("aggregate", "aggregate [flags]")
+	reAggregate := fs.Bool("re-aggregate", false, "re aggregate games even if they are aggregated already")
+	idsFlag := fs.String("ids", "", "comma separated ids of the games to aggregate, instead of all games")
+	views := fs.Bool("views", true, "aggregate the views like company_details after the games, the views of the games given with -ids are refreshed")
+	metricsAddress := fs.String("metrics", "", "serve /metrics on this address while aggregating")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	ids := []uint64{}
+	if *idsFlag != "" {
+		for _, s := range strings.Split(*idsFlag, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil || id == 0 {
+				slog.Error("invalid game id", "id", s)
+				return exitUsage
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	client := newClient()
+	s := newStore(ctx)
+	serveMetrics(ctx, *metricsAddress, s)
+	run := startRun(ctx, s, "aggregate")
+	var aggregated int
+	var err error
+	if len(ids) > 0 {
+		slog.Info("aggregating games", "games", len(ids))
+		aggregated, err = aggregateGamesByIds(ctx, s, client, ids, *views)
+	} else {
+		slog.Info("aggregating games")
+		aggregated, err = aggregateGames(ctx, s, client, *reAggregate)
+	}
+	if err != nil {
+		slog.Error("failed to aggregate games", "aggregated", aggregated, logging.Err(err))
+		return finishRun(ctx, s, run, exitError, fmt.Sprintf("%d games aggregated: %v", aggregated, err))
+	}
+	slog.Info("games aggregated", "aggregated", aggregated)
+	if *views && len(ids) == 0 {
+		if err := aggregateViews(ctx, s, client); err != nil {
+			return finishRun(ctx, s, run, exitError, fmt.Sprintf("%d games aggregated, %v", aggregated, err))
+		}
+	}
+	return finishRun(ctx, s, run, exitOK, fmt.Sprintf("%d games aggregated", aggregated))
+}
+
+// aggregateViews rebuilds every view from the aggregated games.
+func aggregateViews(ctx context.Context, s db.Store, client *igdb.Client) error {
+	for _, v := range db.Views {
+		slog.Info("aggregating view", "view", v.Name())
+		n, err := db.AggregateView(ctx, s, client, v)
+		if err != nil {
+			slog.Error("failed to aggregate view", "view", v.Name(), "aggregated", n, logging.Err(err))
+			return fmt.Errorf("%s: %w", v.Name(), err)
+		}
+		slog.Info("view aggregated", "view", v.Name(), "aggregated", n)
+	}
+	return nil
+}
+
+// aggregateGames aggregates all stored games and returns how many were
+// aggregated. When ctx is cancelled or a batch fails no further batches are
+// started, the batches in flight are still saved.
+func aggregateGames(ctx context.Context, s db.Store, client *igdb.Client, reAggregate bool) (int, error) {
+	total, err := s.EstimatedDocumentCount(ctx, endpoint.EPGames)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count games: %w", err)
+	}
+	slog.Info("games to aggregate", "total", total)
+
+	finished := int64(0)
+	aggregated := int64(0)
+	wg := sync.WaitGroup{}
+
+	concurrenceNum := 10
+	taskOneLoop := int64(500)
+
+	concurrence := make(chan struct{}, concurrenceNum)
+	defer close(concurrence)
+
+	// batches in flight are finished even if ctx is cancelled
+	batchCtx := context.WithoutCancel(ctx)
+	var batchErr error
+	batchErrMu := sync.Mutex{}
+	failed := func() bool {
+		batchErrMu.Lock()
+		defer batchErrMu.Unlock()
+		return batchErr != nil
+	}
+
+batches:
+	for items, err := range db.IterateItems[pb.Game](ctx, s, endpoint.EPGames, taskOneLoop) {
+		if err != nil {
+			if ctx.Err() == nil {
+				batchErrMu.Lock()
+				batchErr = fmt.Errorf("failed to get games: %w", err)
+				batchErrMu.Unlock()
+			}
+			break
+		}
+		select {
+		case concurrence <- struct{}{}:
+		case <-ctx.Done():
+			break batches
+		}
+		if failed() {
+			<-concurrence
+			break
+		}
+		wg.Add(1)
+		go func(items []*pb.Game) {
+			defer func() { <-concurrence }()
+			defer wg.Done()
+			n, err := aggregateBatch(batchCtx, s, client, items, reAggregate)
+			if err != nil {
+				batchErrMu.Lock()
+				if batchErr == nil {
+					batchErr = err
+				}
+				batchErrMu.Unlock()
+				return
+			}
+			atomic.AddInt64(&aggregated, int64(n))
+			p := atomic.AddInt64(&finished, int64(len(items)))
+			slog.Debug("games aggregated", "games", p, "total", total)
+		}(items)
+	}
+	wg.Wait()
+
+	if batchErr != nil {
+		return int(aggregated), batchErr
+	}
+	if err := ctx.Err(); err != nil {
+		return int(aggregated), fmt.Errorf("aggregation interrupted: %w", err)
+	}
+	return int(aggregated), nil
+}
+
+// aggregateBatch aggregates the games of one batch, skipping the aggregated
+// ones unless reAggregate is set, and returns how many were aggregated.
+func aggregateBatch(ctx context.Context, s db.Store, client *igdb.Client, items []*pb.Game, reAggregate bool) (int, error) {
+	toAggregate := items
+	if !reAggregate {
+		ids := make([]uint64, 0, len(items))
+		for _, game := range items {
+			ids = append(ids, game.Id)
+		}
+		isAggregated, err := s.IsGamesAggregated(ctx, ids)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check if games are aggregated: %w", err)
+		}
+		toAggregate = make([]*pb.Game, 0, len(items))
+		for _, item := range items {
+			if !isAggregated[item.Id] {
+				toAggregate = append(toAggregate, item)
+			}
+		}
+	}
+	start := time.Now()
+	games, err := db.ConvertGames(ctx, s, toAggregate, client)
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert games: %w", err)
+	}
+	err = s.SaveGames(ctx, games)
+	if err != nil {
+		return 0, fmt.Errorf("failed to save games: %w", err)
+	}
+	metrics.GamesAggregated(len(games), start)
+	return len(games), nil
+}
+
+// aggregateGamesByIds aggregates the games with the given ids, updates their
+// summaries in related games and, with refreshViews, the view documents
+// containing them. The batch in flight is finished when ctx is cancelled.
+func aggregateGamesByIds(ctx context.Context, s db.Store, client *igdb.Client, ids []uint64, refreshViews bool) (int, error) {
+	taskOneLoop := 500
+	finished := 0
+	aggregated := 0
+	batchCtx := context.WithoutCancel(ctx)
+	for i := 0; i < len(ids); i += taskOneLoop {
+		if err := ctx.Err(); err != nil {
+			return aggregated, fmt.Errorf("aggregation interrupted: %w", err)
+		}
+		items, err := db.GetItemsByIds[pb.Game](batchCtx, s, endpoint.EPGames, ids[i:min(i+taskOneLoop, len(ids))])
+		if err != nil {
+			return aggregated, fmt.Errorf("failed to get games: %w", err)
+		}
+		n, err := aggregateBatch(batchCtx, s, client, items, true)
+		if err != nil {
+			return aggregated, err
+		}
+		err = collector.PatchRelatedSummaries(batchCtx, s, ids[i:min(i+taskOneLoop, len(ids))])
+		if err != nil {
+			return aggregated, fmt.Errorf("failed to patch related game summaries: %w", err)
+		}
+		if refreshViews {
+			err = collector.RefreshViews(batchCtx, s, client, endpoint.EPGames, ids[i:min(i+taskOneLoop, len(ids))])
+			if err != nil {
+				return aggregated, fmt.Errorf("failed to refresh views: %w", err)
+			}
+		}
+		aggregated += n
+		finished += len(items)
+		slog.Debug("games aggregated", "games", finished, "total", len(ids))
+	}
+	return aggregated, nil
+}
@@ -0,0 +1,61 @@
+// Command fake-igdb runs a fake IGDB API for offline integration tests.
+//
+// Point the collector at it with the igdb.proxy and igdb.ca_file options of
+// config.json.
+package main
+
+import (
+	"flag"
+	"igdb-database/fakeigdb"
+	"igdb-database/logging"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/bestnite/go-igdb"
+)
+
+var (
+	address   = flag.String("address", "localhost:9090", "address to listen on")
+	fixtures  = flag.String("fixtures", "", "directory with <endpoint>.json fixture files")
+	caFile    = flag.String("ca-file", "fake-igdb-ca.pem", "file to write the CA certificate to")
+	logLevel  = flag.String("log-level", "info", "debug, info, warn or error")
+	logFormat = flag.String("log-format", "text", "text or json")
+)
+
+func main() {
+	flag.Parse()
+	if err := logging.Setup(*logLevel, *logFormat); err != nil {
+		slog.Error("invalid log options", logging.Err(err))
+		os.Exit(2)
+	}
+
+	s, err := fakeigdb.New()
+	if err != nil {
+		fatal("failed to create fake igdb", err)
+	}
+	// the client is only used to enumerate the endpoints, it never makes a
+	// request
+	if err := fakeigdb.RegisterAll(s, igdb.New("fake", "fake")); err != nil {
+		fatal("failed to register endpoints", err)
+	}
+
+	if *fixtures != "" {
+		if err := s.LoadFixtures(*fixtures); err != nil {
+			fatal("failed to load fixtures", err)
+		}
+	}
+	if err := s.WriteCACert(*caFile); err != nil {
+		fatal("failed to write ca certificate", err)
+	}
+
+	slog.Info("starting fake igdb", "address", *address)
+	if err := http.ListenAndServe(*address, s); err != nil {
+		fatal("failed to start fake igdb", err)
+	}
+}
+
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
+}
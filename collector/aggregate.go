@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/config"
+	"igdb-database/db"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"time"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+)
+
+// AggregateGame converts the stored game with the given id and saves it to
+// the aggregated games. A game that is not stored yet is skipped, it is
+// aggregated once it is stored.
+func AggregateGame(ctx context.Context, s db.Store, id uint64, client *igdb.Client) error {
+	ctx = logging.With(ctx, logging.KeyGameId, id)
+	game, err := db.GetItemById[pb.Game](ctx, s, endpoint.EPGames, id)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get game %d: %w", id, err)
+	}
+	start := time.Now()
+	g, err := db.ConvertGame(ctx, s, game, client)
+	if err != nil {
+		return fmt.Errorf("failed to convert game %d: %w", id, err)
+	}
+	err = s.SaveGame(ctx, g)
+	if err != nil {
+		return fmt.Errorf("failed to save game %d: %w", id, err)
+	}
+	metrics.GamesAggregated(1, start)
+	logging.From(ctx).Info("game aggregated", "duration", time.Since(start))
+	err = PatchRelatedSummaries(ctx, s, []uint64{id})
+	if err != nil {
+		return err
+	}
+	return RefreshViews(ctx, s, client, endpoint.EPGames, []uint64{id})
+}
+
+// PatchRelatedSummaries updates the summaries of the aggregated games with
+// the given ids in the games they are related to, e.g. a similar game whose
+// cover changed.
+func PatchRelatedSummaries(ctx context.Context, s db.Store, ids []uint64) error {
+	if !config.C().Aggregation.RelatedSummaries || len(ids) == 0 {
+		return nil
+	}
+	summaries, err := s.GetGameSummaries(ctx, ids)
+	if err != nil {
+		return err
+	}
+	patched := int64(0)
+	for _, summary := range summaries {
+		n, err := s.PatchRelatedGameSummary(ctx, summary)
+		if err != nil {
+			return err
+		}
+		patched += n
+	}
+	if patched > 0 {
+		logging.From(ctx).Info("related game summaries patched", "games", patched)
+	}
+	return nil
+}
+
+// patchEmbedded updates the copies of a shared item, e.g. a genre or a
+// platform, embedded in aggregated games.
+func patchEmbedded(ctx context.Context, s db.Store, name endpoint.Name, item db.IdGetter) error {
+	if !db.IsEmbeddedInGames(name) {
+		return nil
+	}
+	patched, err := s.PatchEmbeddedItem(ctx, name, item)
+	if err != nil {
+		return err
+	}
+	if patched > 0 {
+		logging.From(ctx).Info("embedded item patched", logging.KeyEndpoint, name, "games", patched)
+	}
+	return nil
+}
+
+// RefreshViews rebuilds the view documents of the items of e with the given
+// ids and the documents containing them, e.g. the company_details of a
+// company whose logo changed or that developed a re-aggregated game.
+func RefreshViews(ctx context.Context, s db.Store, client *igdb.Client, e endpoint.Name, ids []uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, v := range db.Views {
+		docIds := ids
+		if v.EndpointName() != e {
+			var err error
+			docIds, err = s.ViewDocumentIds(ctx, v, e, ids)
+			if err != nil {
+				return err
+			}
+		}
+		if len(docIds) == 0 {
+			continue
+		}
+		docs, err := v.Build(ctx, s, client, docIds)
+		if err != nil {
+			return fmt.Errorf("failed to build %s: %w", v.Name(), err)
+		}
+		err = s.SaveViewDocuments(ctx, v.Name(), docs)
+		if err != nil {
+			return err
+		}
+		if len(docs) > 0 {
+			logging.From(ctx).Info("view refreshed", "view", v.Name(), "documents", len(docs))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,374 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"igdb-database/db"
+	"igdb-database/logging"
+	"igdb-database/model"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	pb "github.com/bestnite/go-igdb/proto"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const (
+	maxIdsPerRequest   = 500
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/games/{id}", s.getGame)
+	mux.HandleFunc("GET /v1/games/by-slug/{slug}", s.getGameBySlug)
+	// a literal /v1/games/{id}/family would conflict with by-slug/{slug},
+	// which is more specific than {id}/{relation}
+	mux.HandleFunc("GET /v1/games/{id}/{relation}", s.getGameRelation)
+	mux.HandleFunc("GET /v1/games", s.getGames)
+	mux.HandleFunc("GET /v1/search", s.searchGames)
+	mux.HandleFunc("GET /v1/external/{source}/{uid}", s.getExternalGame)
+	mux.HandleFunc("GET /v1/external/{source}", s.getExternalGames)
+	for _, v := range db.Views {
+		mux.HandleFunc("GET /v1/"+v.Path()+"/{id}", s.getViewDocument(v))
+		mux.HandleFunc("GET /v1/"+v.Path(), s.getViewDocuments(v))
+	}
+}
+
+func (s *Server) getGame(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		writeError(w, http.StatusBadRequest, "invalid game id")
+		return
+	}
+	game, err := s.db.GetGameById(r.Context(), id)
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+func (s *Server) getGameBySlug(w http.ResponseWriter, r *http.Request) {
+	slug := r.PathValue("slug")
+	if slug == "" {
+		writeError(w, http.StatusBadRequest, "invalid game slug")
+		return
+	}
+	game, err := s.db.GetGameBySlug(r.Context(), slug)
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+	writeJSON(w, http.StatusOK, game)
+}
+
+func (s *Server) getGameRelation(w http.ResponseWriter, r *http.Request) {
+	relation := r.PathValue("relation")
+	if relation != "family" {
+		writeError(w, http.StatusNotFound, "unknown relation: "+relation)
+		return
+	}
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil || id == 0 {
+		writeError(w, http.StatusBadRequest, "invalid game id")
+		return
+	}
+	depth, err := parseIntParam(r.URL.Query().Get("depth"), db.MaxFamilyDepth)
+	if err != nil || depth < 1 || depth > db.MaxFamilyDepth {
+		writeError(w, http.StatusBadRequest, "invalid depth, must be between 1 and "+strconv.Itoa(db.MaxFamilyDepth))
+		return
+	}
+	family, err := db.GetGameFamily(r.Context(), s.db, id, depth)
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+	writeJSON(w, http.StatusOK, family)
+}
+
+func (s *Server) getGames(w http.ResponseWriter, r *http.Request) {
+	ids, err := parseIds(r.URL.Query().Get("ids"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	games, err := s.db.GetGamesByIds(r.Context(), ids)
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+
+	// keep the order requested by the caller
+	gameMap := make(map[uint64]*model.Game, len(games))
+	for _, game := range games {
+		gameMap[game.Id] = game
+	}
+	res := make([]*model.Game, 0, len(games))
+	for _, id := range ids {
+		if game, ok := gameMap[id]; ok {
+			res = append(res, game)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) searchGames(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		writeError(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	page, err := parseIntParam(query.Get("page"), 1)
+	if err != nil || page < 1 {
+		writeError(w, http.StatusBadRequest, "invalid page")
+		return
+	}
+	pageSize, err := parseIntParam(query.Get("page_size"), defaultSearchLimit)
+	if err != nil || pageSize < 1 || pageSize > maxSearchLimit {
+		writeError(w, http.StatusBadRequest, "invalid page_size, must be between 1 and "+strconv.Itoa(maxSearchLimit))
+		return
+	}
+
+	res, err := s.db.SearchGames(r.Context(), q, page, pageSize)
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) getExternalGame(w http.ResponseWriter, r *http.Request) {
+	source, ok := s.findExternalGameSource(w, r)
+	if !ok {
+		return
+	}
+	uid := r.PathValue("uid")
+	matches, err := db.GetGamesByExternalIds(r.Context(), s.db, source, []string{uid})
+	if err != nil {
+		writeDBError(w, err, "external game")
+		return
+	}
+	if len(matches) == 0 {
+		writeError(w, http.StatusNotFound, "external game not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, matches[0])
+}
+
+// getExternalGames returns the matches of the given uids in request order
+// and the uids without a match.
+func (s *Server) getExternalGames(w http.ResponseWriter, r *http.Request) {
+	source, ok := s.findExternalGameSource(w, r)
+	if !ok {
+		return
+	}
+	uids, err := parseUids(r.URL.Query().Get("uids"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	matches, err := db.GetGamesByExternalIds(r.Context(), s.db, source, uids)
+	if err != nil {
+		writeDBError(w, err, "external game")
+		return
+	}
+
+	res := &model.ExternalLookupResult{Source: source.Name, Matches: matches, Missing: []string{}}
+	matched := make(map[string]bool, len(matches))
+	for _, match := range matches {
+		matched[match.Uid] = true
+	}
+	for _, uid := range uids {
+		if !matched[uid] {
+			res.Missing = append(res.Missing, uid)
+		}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// findExternalGameSource resolves the source path value and writes an error
+// response if it does not exist or is ambiguous.
+func (s *Server) findExternalGameSource(w http.ResponseWriter, r *http.Request) (*pb.ExternalGameSource, bool) {
+	source, err := db.FindExternalGameSource(r.Context(), s.db, r.PathValue("source"))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		writeError(w, http.StatusNotFound, "external game source not found")
+		return nil, false
+	case errors.Is(err, db.ErrAmbiguousSource):
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	case err != nil:
+		writeDBError(w, err, "external game source")
+		return nil, false
+	}
+	return source, true
+}
+
+func (s *Server) getViewDocument(v db.View) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+		if err != nil || id == 0 {
+			writeError(w, http.StatusBadRequest, "invalid id")
+			return
+		}
+		doc, err := s.db.GetViewDocument(r.Context(), v.Name(), id)
+		if err != nil {
+			writeDBError(w, err, "document")
+			return
+		}
+		writeDocument(w, doc)
+	}
+}
+
+// getViewDocuments returns the documents with the given ids in request
+// order.
+func (s *Server) getViewDocuments(v db.View) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ids, err := parseIds(r.URL.Query().Get("ids"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		docs, err := s.db.GetViewDocuments(r.Context(), v.Name(), ids)
+		if err != nil {
+			writeDBError(w, err, "document")
+			return
+		}
+
+		docMap := make(map[int64]bson.Raw, len(docs))
+		for _, doc := range docs {
+			docMap[doc.Lookup("id").AsInt64()] = doc
+		}
+		res := make([]bson.Raw, 0, len(docs))
+		for _, id := range ids {
+			if doc, ok := docMap[int64(id)]; ok {
+				res = append(res, doc)
+			}
+		}
+		writeDocuments(w, res)
+	}
+}
+
+func parseIntParam(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	return strconv.Atoi(s)
+}
+
+func parseIds(s string) ([]uint64, error) {
+	if s == "" {
+		return nil, errors.New("ids is required")
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > maxIdsPerRequest {
+		return nil, errors.New("too many ids, at most " + strconv.Itoa(maxIdsPerRequest) + " allowed")
+	}
+	ids := make([]uint64, 0, len(parts))
+	seen := make(map[uint64]bool, len(parts))
+	for _, part := range parts {
+		id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+		if err != nil || id == 0 {
+			return nil, errors.New("invalid id: " + part)
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func parseUids(s string) ([]string, error) {
+	if s == "" {
+		return nil, errors.New("uids is required")
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) > maxIdsPerRequest {
+		return nil, errors.New("too many uids, at most " + strconv.Itoa(maxIdsPerRequest) + " allowed")
+	}
+	uids := make([]string, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		uid := strings.TrimSpace(part)
+		if uid == "" {
+			return nil, errors.New("invalid uid: " + part)
+		}
+		if seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// writeDBError writes a not found response naming resource for
+// ErrNotFound, and an internal server error for other errors.
+func writeDBError(w http.ResponseWriter, err error, resource string) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		writeError(w, http.StatusNotFound, resource+" not found")
+		return
+	}
+	slog.Error("failed to query database", logging.Err(err))
+	writeError(w, http.StatusInternalServerError, "internal server error")
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorResponse{Error: msg})
+}
+
+// writeDocument writes doc as a JSON object, numbers are written as plain
+// JSON numbers.
+func writeDocument(w http.ResponseWriter, doc bson.Raw) {
+	body, err := bson.MarshalExtJSON(doc, false, false)
+	if err != nil {
+		slog.Error("failed to encode document", logging.Err(err))
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	writeBody(w, body)
+}
+
+// writeDocuments writes docs as a JSON array like writeDocument.
+func writeDocuments(w http.ResponseWriter, docs []bson.Raw) {
+	parts := make([][]byte, 0, len(docs))
+	for _, doc := range docs {
+		part, err := bson.MarshalExtJSON(doc, false, false)
+		if err != nil {
+			slog.Error("failed to encode document", logging.Err(err))
+			writeError(w, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		parts = append(parts, part)
+	}
+	writeBody(w, slices.Concat([]byte("["), bytes.Join(parts, []byte(",")), []byte("]")))
+}
+
+func writeBody(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(append(body, '\n')); err != nil {
+		slog.Error("failed to write response", logging.Err(err))
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", logging.Err(err))
+	}
+}
@@ -0,0 +1,260 @@
+package collector
+
+import (
+	"igdb-database/logging"
+	"igdb-database/model"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+const (
+	// igdbCheckInterval limits how often /readyz sends a request to IGDB.
+	igdbCheckInterval = time.Minute
+	// remoteCountsMaxAge is how long the IGDB counts on /status are reused.
+	remoteCountsMaxAge = 30 * time.Minute
+)
+
+// health is the state the health, readiness and status endpoints report.
+type health struct {
+	mu             sync.Mutex
+	registered     bool
+	lastWebhook    map[endpoint.Name]time.Time
+	remoteCounts   map[endpoint.Name]uint64
+	remoteCountsAt time.Time
+	counting       bool
+
+	// igdbMu is held during the IGDB request of the readiness check, so
+	// webhooks are not blocked by it.
+	igdbMu        sync.Mutex
+	igdbCheckedAt time.Time
+	igdbErr       error
+}
+
+func newHealth(register bool) *health {
+	return &health{
+		// without registration there is nothing to wait for
+		registered:  !register,
+		lastWebhook: make(map[endpoint.Name]time.Time),
+	}
+}
+
+func (h *health) setRegistered() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.registered = true
+}
+
+func (h *health) webhookReceived(name endpoint.Name) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastWebhook[name] = time.Now()
+}
+
+func (s *Server) registerHealthAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("GET /readyz", s.readyz)
+	mux.HandleFunc("GET /status", s.status)
+}
+
+// healthz only reports that the process is serving requests.
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, struct {
+		Status string `json:"status"`
+	}{Status: "ok"})
+}
+
+type readinessCheck struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// readyz reports whether MongoDB is reachable, the IGDB credentials work and
+// the webhooks are registered.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []readinessCheck{
+		newReadinessCheck("mongodb", s.db.Ping(r.Context())),
+		newReadinessCheck("igdb", s.checkIgdb()),
+	}
+	s.health.mu.Lock()
+	registered := s.health.registered
+	s.health.mu.Unlock()
+	checks = append(checks, readinessCheck{Name: "webhooks", Ok: registered})
+	if !registered {
+		checks[len(checks)-1].Error = "webhooks are not registered yet"
+	}
+
+	status := http.StatusOK
+	for _, c := range checks {
+		if !c.Ok {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(w, status, struct {
+		Ready  bool             `json:"ready"`
+		Checks []readinessCheck `json:"checks"`
+	}{Ready: status == http.StatusOK, Checks: checks})
+}
+
+func newReadinessCheck(name string, err error) readinessCheck {
+	if err != nil {
+		return readinessCheck{Name: name, Error: err.Error()}
+	}
+	return readinessCheck{Name: name, Ok: true}
+}
+
+// checkIgdb sends a small request to IGDB, which needs a valid token. The
+// result is reused for igdbCheckInterval so probes do not use up the rate
+// limit.
+func (s *Server) checkIgdb() error {
+	s.health.igdbMu.Lock()
+	defer s.health.igdbMu.Unlock()
+	if time.Since(s.health.igdbCheckedAt) < igdbCheckInterval {
+		return s.health.igdbErr
+	}
+	p, ok := s.processors[endpoint.EPGameTypes]
+	if !ok {
+		return nil
+	}
+	_, s.health.igdbErr = p.count()
+	s.health.igdbCheckedAt = time.Now()
+	return s.health.igdbErr
+}
+
+type endpointStatus struct {
+	Endpoint      endpoint.Name `json:"endpoint"`
+	Local         int64         `json:"local"`
+	Igdb          *uint64       `json:"igdb,omitempty"`
+	LastWebhookAt *time.Time    `json:"last_webhook_at,omitempty"`
+	FetchStarted  *time.Time    `json:"fetch_started_at,omitempty"`
+	FetchFinished *time.Time    `json:"fetch_finished_at,omitempty"`
+	FailedPages   int           `json:"failed_pages"`
+}
+
+// status compares the local collections with IGDB and shows the last
+// webhook and the last runs of the batch commands. The IGDB counts are
+// refreshed in the background, so they may be missing or up to
+// remoteCountsMaxAge old.
+func (s *Server) status(w http.ResponseWriter, r *http.Request) {
+	s.refreshRemoteCounts()
+
+	states, err := s.db.GetSyncStates(r.Context())
+	if err != nil {
+		writeDBError(w, err, "sync state")
+		return
+	}
+	stateByName := make(map[endpoint.Name]*model.SyncState, len(states))
+	for _, state := range states {
+		stateByName[state.Endpoint] = state
+	}
+
+	s.health.mu.Lock()
+	remoteCounts := s.health.remoteCounts
+	remoteCountsAt := s.health.remoteCountsAt
+	lastWebhook := make(map[endpoint.Name]time.Time, len(s.health.lastWebhook))
+	for name, t := range s.health.lastWebhook {
+		lastWebhook[name] = t
+	}
+	s.health.mu.Unlock()
+
+	endpoints := []endpointStatus{}
+	var lastWebhookAt *time.Time
+	for _, name := range WebhookEndpoints() {
+		local, err := s.db.EstimatedDocumentCount(r.Context(), name)
+		if err != nil {
+			writeDBError(w, err, string(name))
+			return
+		}
+		es := endpointStatus{Endpoint: name, Local: local}
+		if count, ok := remoteCounts[name]; ok {
+			es.Igdb = &count
+		}
+		if t, ok := lastWebhook[name]; ok {
+			es.LastWebhookAt = &t
+			if lastWebhookAt == nil || t.After(*lastWebhookAt) {
+				lastWebhookAt = &t
+			}
+		}
+		if state, ok := stateByName[name]; ok {
+			es.FetchStarted = &state.StartedAt
+			es.FetchFinished = state.FinishedAt
+			es.FailedPages = len(state.FailedOffsets)
+		}
+		endpoints = append(endpoints, es)
+	}
+
+	games, err := s.db.CountGames(r.Context())
+	if err != nil {
+		writeDBError(w, err, "game")
+		return
+	}
+	queued, err := s.db.CountWebhookEvents(r.Context())
+	if err != nil {
+		writeDBError(w, err, "webhook event")
+		return
+	}
+	deadLetters, err := s.db.CountDeadLetters(r.Context())
+	if err != nil {
+		writeDBError(w, err, "dead letter")
+		return
+	}
+	runs, err := s.db.GetRuns(r.Context())
+	if err != nil {
+		writeDBError(w, err, "run")
+		return
+	}
+
+	res := struct {
+		Games          int64            `json:"games"`
+		QueuedWebhooks int64            `json:"queued_webhooks"`
+		DeadLetters    int64            `json:"dead_letters"`
+		LastWebhookAt  *time.Time       `json:"last_webhook_at,omitempty"`
+		IgdbCountedAt  *time.Time       `json:"igdb_counted_at,omitempty"`
+		Runs           []*model.Run     `json:"runs"`
+		Endpoints      []endpointStatus `json:"endpoints"`
+	}{
+		Games:          games,
+		QueuedWebhooks: queued,
+		DeadLetters:    deadLetters,
+		LastWebhookAt:  lastWebhookAt,
+		Runs:           runs,
+		Endpoints:      endpoints,
+	}
+	if !remoteCountsAt.IsZero() {
+		res.IgdbCountedAt = &remoteCountsAt
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// refreshRemoteCounts counts the items of every endpoint on IGDB in the
+// background if the counts are older than remoteCountsMaxAge.
+func (s *Server) refreshRemoteCounts() {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+	if s.health.counting || time.Since(s.health.remoteCountsAt) < remoteCountsMaxAge {
+		return
+	}
+	s.health.counting = true
+
+	go func() {
+		counts := make(map[endpoint.Name]uint64, len(s.processors))
+		for name, p := range s.processors {
+			count, err := p.count()
+			if err != nil {
+				slog.Warn("failed to count on igdb", logging.KeyEndpoint, name, logging.Err(err))
+				continue
+			}
+			counts[name] = count
+		}
+
+		s.health.mu.Lock()
+		defer s.health.mu.Unlock()
+		s.health.remoteCounts = counts
+		s.health.remoteCountsAt = time.Now()
+		s.health.counting = false
+	}()
+}
@@ -0,0 +1,41 @@
+package collector
+
+import (
+	"igdb-database/db"
+	"slices"
+
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+// dependencies returns the endpoints that have to be fetched before e.
+func dependencies(e endpoint.Name) []endpoint.Name {
+	if e == endpoint.EPGames {
+		return db.GameRelationEndpoints()
+	}
+	return nil
+}
+
+// FetchOrder orders names so that the collections referenced by an endpoint
+// are fetched before it, e.g. the relations of games before games. Otherwise
+// the given order is kept.
+func FetchOrder(names []endpoint.Name) []endpoint.Name {
+	ordered := make([]endpoint.Name, 0, len(names))
+	visited := make(map[endpoint.Name]bool, len(names))
+	var visit func(e endpoint.Name)
+	visit = func(e endpoint.Name) {
+		if visited[e] {
+			return
+		}
+		visited[e] = true
+		for _, dep := range dependencies(e) {
+			if slices.Contains(names, dep) {
+				visit(dep)
+			}
+		}
+		ordered = append(ordered, e)
+	}
+	for _, e := range names {
+		visit(e)
+	}
+	return ordered
+}
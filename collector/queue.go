@@ -0,0 +1,285 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"igdb-database/config"
+	"igdb-database/db"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"igdb-database/model"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	webhookWorkerNum      = 5
+	webhookMaxAttempts    = 8
+	webhookLockDuration   = 5 * time.Minute
+	webhookRetryBaseDelay = 5 * time.Second
+	webhookRetryMaxDelay  = time.Hour
+	webhookPollInterval   = time.Second
+)
+
+// errWorkerStopped cancels the events still applied shutdownTimeout after
+// the workers were stopped.
+var errWorkerStopped = errors.New("webhook worker stopped")
+
+// startWebhookWorkers starts n workers, which stop claiming events when ctx
+// is cancelled. The returned WaitGroup is done when all of them finished
+// their current event.
+func (s *Server) startWebhookWorkers(ctx context.Context, n int) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.webhookWorker(ctx)
+		}()
+	}
+	return wg
+}
+
+func (s *Server) webhookWorker(ctx context.Context) {
+	for ctx.Err() == nil {
+		event, err := s.db.ClaimWebhookEvent(ctx, webhookLockDuration)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Error("failed to claim webhook event", logging.Err(err))
+			}
+			sleep(ctx, 5*webhookPollInterval)
+			continue
+		}
+		if event == nil {
+			sleep(ctx, webhookPollInterval)
+			continue
+		}
+		eventCtx, cancel := eventContext(ctx)
+		s.processWebhookEvent(eventCtx, event)
+		cancel()
+	}
+}
+
+// eventContext returns the context a claimed event is applied with. The
+// event is finished even if ctx is cancelled, but it is cancelled
+// shutdownTimeout later, and after webhookLockDuration, when another worker
+// may have claimed it.
+func eventContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	eventCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	eventCtx, cancelTimeout := context.WithTimeout(eventCtx, webhookLockDuration)
+	stop := context.AfterFunc(ctx, func() {
+		t := time.NewTimer(shutdownTimeout)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			cancel(errWorkerStopped)
+		case <-eventCtx.Done():
+		}
+	})
+	return eventCtx, func() {
+		stop()
+		cancelTimeout()
+		cancel(nil)
+	}
+}
+
+// waitForWorkers waits until the workers returned or timeout passed. It
+// returns false if they did not return in time.
+func waitForWorkers(workers *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-done:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+// sleep waits for d or until ctx is cancelled.
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+func (s *Server) processWebhookEvent(ctx context.Context, event *model.WebhookEvent) {
+	ctx = logging.With(ctx,
+		logging.KeyRequestId, event.RequestId,
+		logging.KeyWebhookEventId, event.MId.Hex(),
+		logging.KeyEndpoint, event.Endpoint,
+		logging.KeyEntityId, event.EntityId,
+		"method", event.Method,
+		"attempt", event.Attempts+1,
+	)
+	logger := logging.From(ctx)
+
+	// attempts whose worker died or hung are counted when the expired lock
+	// is claimed again, so such an event is not applied forever
+	if event.Attempts >= webhookMaxAttempts {
+		metrics.WebhookFailed(string(event.Endpoint), string(event.Method), true)
+		logger.Error("webhook event lock expired too often, dead lettered")
+		err := fmt.Errorf("lock expired after %d attempts", event.Attempts)
+		if err := s.db.DeadLetterWebhookEvent(ctx, event, err); err != nil {
+			logQueueError(logger, "failed to dead letter webhook event", err)
+		}
+		return
+	}
+
+	logger.Debug("processing webhook event")
+	err := s.applyWebhookEvent(ctx, event)
+	if ctx.Err() != nil {
+		// the queue can't be updated either, the event is claimed again
+		// once its lock expires
+		logger.Warn("webhook event abandoned", "cause", context.Cause(ctx), logging.Err(err))
+		return
+	}
+	if err == nil {
+		metrics.WebhookProcessed(string(event.Endpoint), string(event.Method))
+		if err := s.db.CompleteWebhookEvent(ctx, event); err != nil {
+			logQueueError(logger, "failed to complete webhook event", err)
+		}
+		return
+	}
+
+	deadLettered := event.Attempts+1 >= webhookMaxAttempts
+	metrics.WebhookFailed(string(event.Endpoint), string(event.Method), deadLettered)
+	if deadLettered {
+		logger.Error("webhook event failed too often, dead lettered", logging.Err(err))
+		if err := s.db.DeadLetterWebhookEvent(ctx, event, err); err != nil {
+			logQueueError(logger, "failed to dead letter webhook event", err)
+		}
+		return
+	}
+
+	delay := retryDelay(event.Attempts)
+	logger.Warn("webhook event failed, retrying", "retry_in", delay, logging.Err(err))
+	if err := s.db.RetryWebhookEvent(ctx, event, err, time.Now().Add(delay)); err != nil {
+		logQueueError(logger, "failed to retry webhook event", err)
+	}
+}
+
+// logQueueError logs a failed queue update. A lost lock means the event
+// took longer than webhookLockDuration and another worker claimed it.
+func logQueueError(logger *slog.Logger, msg string, err error) {
+	if errors.Is(err, db.ErrWebhookLockLost) {
+		logger.Warn(msg+", it was claimed by another worker", logging.Err(err))
+		return
+	}
+	logger.Error(msg, logging.Err(err))
+}
+
+func (s *Server) applyWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	p, ok := s.processors[event.Endpoint]
+	if !ok {
+		return fmt.Errorf("no webhook processor for %s", event.Endpoint)
+	}
+	switch event.Method {
+	case model.WebhookMethodUpsert:
+		return p.upsert(ctx, event.EntityId)
+	case model.WebhookMethodDelete:
+		return p.remove(ctx, event.EntityId)
+	default:
+		return fmt.Errorf("unknown webhook method %s", event.Method)
+	}
+}
+
+// retryDelay doubles the delay with every attempt up to webhookRetryMaxDelay.
+func retryDelay(attempts int) time.Duration {
+	delay := webhookRetryBaseDelay
+	for range attempts {
+		delay *= 2
+		if delay >= webhookRetryMaxDelay {
+			return webhookRetryMaxDelay
+		}
+	}
+	return delay
+}
+
+func (s *Server) registerQueueAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/webhooks/dead-letters", requireSecret(s.listDeadLetters))
+	mux.HandleFunc("POST /v1/webhooks/dead-letters/replay", requireSecret(s.replayDeadLetters))
+}
+
+// requireSecret protects administrative endpoints with the webhook secret.
+func requireSecret(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Secret") != config.C().WebhookSecret {
+			writeError(w, http.StatusUnauthorized, "invalid secret")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var after bson.ObjectID
+	if hex := query.Get("after"); hex != "" {
+		id, err := bson.ObjectIDFromHex(hex)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid after: "+hex)
+			return
+		}
+		after = id
+	}
+	pageSize, err := parseIntParam(query.Get("page_size"), 100)
+	if err != nil || pageSize < 1 || pageSize > maxIdsPerRequest {
+		writeError(w, http.StatusBadRequest, "invalid page_size, must be between 1 and "+strconv.Itoa(maxIdsPerRequest))
+		return
+	}
+
+	events, err := s.db.GetDeadLetters(r.Context(), after, int64(pageSize))
+	if err != nil {
+		writeDBError(w, err, "dead letter")
+		return
+	}
+	writeJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) replayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	body := struct {
+		Ids []string `json:"ids"`
+	}{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	ids := make([]bson.ObjectID, 0, len(body.Ids))
+	for _, hex := range body.Ids {
+		id, err := bson.ObjectIDFromHex(hex)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid id: "+hex)
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	replayed, err := s.db.ReplayDeadLetters(r.Context(), ids)
+	if err != nil {
+		slog.Error("failed to replay dead letters", logging.Err(err))
+		writeError(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Replayed int `json:"replayed"`
+	}{Replayed: replayed})
+}
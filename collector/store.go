@@ -1,52 +1,253 @@
 package collector
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"igdb-database/db"
-	"log"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"igdb-database/model"
 	"math"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
 )
 
+// FetchAndStore fetches all items of e page by page. Progress is persisted
+// in sync_state, so an interrupted fetch resumes from the pages that are not
+// stored yet, unless restart is set. It returns the offsets of the pages
+// that failed.
+//
+// When ctx is cancelled no further pages are started, the pages in flight
+// are still stored so the fetch can be resumed.
 func FetchAndStore[T any](
+	ctx context.Context,
+	s db.Store,
 	e endpoint.EntityEndpoint[T],
-) {
-	total, err := e.Count()
+	restart bool,
+) ([]uint64, error) {
+	ctx = logging.With(ctx, logging.KeyEndpoint, e.GetEndpointName())
+	logger := logging.From(ctx)
+	state, err := s.GetSyncState(ctx, e.GetEndpointName())
 	if err != nil {
-		log.Fatalf("failed to get %s length: %v", e.GetEndpointName(), err)
+		return nil, err
 	}
-	log.Printf("%s length: %d", e.GetEndpointName(), total)
+	if restart && state != nil && !state.IsFinished() {
+		logger.Info("discarding unfinished fetch", "stored_pages", len(state.CompletedOffsets), "failed_pages", len(state.FailedOffsets))
+	}
+	if restart || state == nil || state.IsFinished() {
+		start := time.Now()
+		total, err := e.Count()
+		metrics.IgdbRequest(string(e.GetEndpointName()), "count", start, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s length: %w", e.GetEndpointName(), err)
+		}
+		state = &model.SyncState{
+			Endpoint:         e.GetEndpointName(),
+			Total:            total,
+			PageSize:         500,
+			CompletedOffsets: []uint64{},
+			FailedOffsets:    []uint64{},
+			StartedAt:        time.Now(),
+		}
+		err = s.StartSyncState(ctx, state)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		logger.Info("resuming fetch", "stored_pages", len(state.CompletedOffsets), "failed_pages", len(state.FailedOffsets))
+	}
+	logger.Info("fetching items", "total", state.Total)
+
+	completed := make(map[uint64]bool, len(state.CompletedOffsets))
+	for _, offset := range state.CompletedOffsets {
+		completed[offset] = true
+	}
+
 	wg := sync.WaitGroup{}
 	concurrence := make(chan struct{}, 3)
 	defer close(concurrence)
 
-	totalSteps := int(math.Ceil(float64(total) / 500))
-	finished := int32(0)
+	totalSteps := int(math.Ceil(float64(state.Total) / float64(state.PageSize)))
+	finished := int32(len(completed))
+	failed := []uint64{}
+	failedMu := sync.Mutex{}
 
-	for i := 0; i < int(total); i += 500 {
+	// pages in flight are finished even if ctx is cancelled
+	pageCtx := context.WithoutCancel(ctx)
+pages:
+	for i := uint64(0); i < state.Total; i += state.PageSize {
+		if completed[i] {
+			continue
+		}
+		select {
+		case concurrence <- struct{}{}:
+		case <-ctx.Done():
+			break pages
+		}
 		wg.Add(1)
-		concurrence <- struct{}{}
-		go func(i int) {
+		go func(i uint64) {
 			defer wg.Done()
 			defer func() { <-concurrence }()
 
-			items, err := e.Paginated(uint64(i), 500)
+			err := fetchPage(pageCtx, s, e, i, state.PageSize)
 			if err != nil {
-				log.Printf("failed to get items from igdb %s: %v", e.GetEndpointName(), err)
+				logger.Error("failed to fetch page", "offset", i, logging.Err(err))
+				failedMu.Lock()
+				failed = append(failed, i)
+				failedMu.Unlock()
+				if err := s.MarkPageFailed(pageCtx, e.GetEndpointName(), i, err); err != nil {
+					logger.Error("failed to mark page failed", "offset", i, logging.Err(err))
+				}
 				return
 			}
-
-			err = db.SaveItems(e.GetEndpointName(), items)
-			if err != nil {
-				log.Printf("failed to save games %s: %v", e.GetEndpointName(), err)
-				return
+			if err := s.MarkPageCompleted(pageCtx, e.GetEndpointName(), i); err != nil {
+				logger.Error("failed to mark page completed", "offset", i, logging.Err(err))
 			}
 
 			cur := atomic.AddInt32(&finished, 1)
-			log.Printf("%s finished: %d/%d", e.GetEndpointName(), cur, totalSteps)
+			logger.Debug("page fetched", "offset", i, "pages", cur, "total_pages", totalSteps)
 		}(i)
 	}
 	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("fetch of %s interrupted: %w", e.GetEndpointName(), err)
+	}
+	if len(failed) > 0 {
+		slices.Sort(failed)
+		return failed, nil
+	}
+	return nil, s.FinishSyncState(ctx, e.GetEndpointName())
+}
+
+// fetchPage stores the page of e at offset. Pages are sorted by id so the
+// offsets of a resumed fetch address the same items.
+func fetchPage[T any](ctx context.Context, s db.Store, e endpoint.EntityEndpoint[T], offset uint64, limit uint64) error {
+	start := time.Now()
+	items, err := e.Query(fmt.Sprintf("fields *; sort id asc; offset %d; limit %d;", offset, limit))
+	metrics.IgdbRequest(string(e.GetEndpointName()), "paginated", start, err)
+	if err != nil {
+		return fmt.Errorf("failed to get items from igdb %s at offset %d: %w", e.GetEndpointName(), offset, err)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	err = db.SaveItems(ctx, s, e.GetEndpointName(), items)
+	if err != nil {
+		return fmt.Errorf("failed to save %s at offset %d: %w", e.GetEndpointName(), offset, err)
+	}
+	return nil
+}
+
+// IsFetchUnfinished reports whether a previous fetch of e was interrupted or
+// left failed pages behind.
+func IsFetchUnfinished(ctx context.Context, s db.Store, e endpoint.Name) bool {
+	state, err := s.GetSyncState(ctx, e)
+	if err != nil {
+		logging.From(ctx).Error("failed to get sync state", logging.KeyEndpoint, e, logging.Err(err))
+		return false
+	}
+	return state != nil && !state.IsFinished()
+}
+
+// FetchUpdatedAndStore fetches only the items of e updated at or after the
+// newest updated_at stored locally and returns the ids of the games they
+// belong to. updated_at has second granularity, so items of that second are
+// fetched again; saving them twice is harmless.
+// An empty collection is fetched in full and fetchedAll is set, since any
+// game may reference its items and all games need to be aggregated again.
+// Endpoints without updated_at are skipped since their items are fetched on
+// demand during aggregation.
+func FetchUpdatedAndStore[T any](
+	ctx context.Context,
+	s db.Store,
+	e endpoint.EntityEndpoint[T],
+) (gameIds []uint64, fetchedAll bool, err error) {
+	ctx = logging.With(ctx, logging.KeyEndpoint, e.GetEndpointName())
+	logger := logging.From(ctx)
+	since, err := s.GetLatestUpdatedAt(ctx, e.GetEndpointName())
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			logger.Info("collection is empty, fetching all items")
+			failed, err := FetchAndStore(ctx, s, e, false)
+			if err == nil && len(failed) > 0 {
+				err = fmt.Errorf("%d pages of %s failed", len(failed), e.GetEndpointName())
+			}
+			return nil, true, err
+		}
+		return nil, false, err
+	}
+	if since == nil {
+		logger.Info("items have no updated_at, skipped")
+		return nil, false, nil
+	}
+
+	gameIds = []uint64{}
+	total := 0
+	for offset := 0; ; offset += 500 {
+		if err := ctx.Err(); err != nil {
+			return gameIds, false, fmt.Errorf("fetch of updated %s interrupted: %w", e.GetEndpointName(), err)
+		}
+		start := time.Now()
+		items, err := e.Query(fmt.Sprintf("fields *; where updated_at >= %d; sort id asc; offset %d; limit 500;", since.Seconds, offset))
+		metrics.IgdbRequest(string(e.GetEndpointName()), "query", start, err)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get updated items from igdb %s: %w", e.GetEndpointName(), err)
+		}
+		if len(items) == 0 {
+			break
+		}
+
+		err = db.SaveItems(ctx, s, e.GetEndpointName(), items)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to save %s: %w", e.GetEndpointName(), err)
+		}
+		viewIds := []uint64{}
+		for _, item := range items {
+			if id, ok := affectedGameId(item); ok {
+				gameIds = append(gameIds, id)
+				continue
+			}
+			viewIds = append(viewIds, any(item).(db.IdGetter).GetId())
+			if err := patchEmbedded(ctx, s, e.GetEndpointName(), any(item).(db.IdGetter)); err != nil {
+				logger.Error("failed to patch embedded item", logging.KeyEntityId, any(item).(db.IdGetter).GetId(), logging.Err(err))
+			}
+		}
+		if err := RefreshViews(ctx, s, nil, e.GetEndpointName(), viewIds); err != nil {
+			logger.Error("failed to refresh views", logging.Err(err))
+		}
+
+		total += len(items)
+		logger.Debug("updated items fetched", "items", total)
+		if len(items) < 500 {
+			break
+		}
+	}
+	logger.Info("updated items stored", "items", total)
+	return gameIds, false, nil
+}
+
+// affectedGameId returns the id of the game that has to be re-aggregated
+// when item changes.
+func affectedGameId(item any) (uint64, bool) {
+	type gameGetter interface {
+		GetGame() *pb.Game
+	}
+
+	switch v := item.(type) {
+	case *pb.Game:
+		return v.Id, true
+	case gameGetter:
+		if v.GetGame() != nil {
+			return v.GetGame().Id, true
+		}
+	}
+	return 0, false
 }
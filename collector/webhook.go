@@ -1,219 +1,373 @@
 package collector
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"igdb-database/config"
 	"igdb-database/db"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"igdb-database/model"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"slices"
-
-	pb "github.com/bestnite/go-igdb/proto"
+	"time"
 
 	"github.com/bestnite/go-igdb"
 	"github.com/bestnite/go-igdb/endpoint"
-	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
-func StartWebhookServer(client *igdb.Client) {
-	baseUrl, err := url.Parse(config.C().ExternalUrl)
-	if err != nil {
-		log.Fatalf("failed to parse url: %v", err)
-	}
-
-	http.HandleFunc(webhook(client.AgeRatingCategories, client))
-	http.HandleFunc(webhook(client.AgeRatingContentDescriptions, client))
-	http.HandleFunc(webhook(client.AgeRatingContentDescriptionsV2, client))
-	http.HandleFunc(webhook(client.AgeRatingOrganizations, client))
-	http.HandleFunc(webhook(client.AgeRatings, client))
-	http.HandleFunc(webhook(client.AlternativeNames, client))
-	http.HandleFunc(webhook(client.Artworks, client))
-	http.HandleFunc(webhook(client.CharacterGenders, client))
-	http.HandleFunc(webhook(client.CharacterMugShots, client))
-	http.HandleFunc(webhook(client.Characters, client))
-	http.HandleFunc(webhook(client.CharacterSpecies, client))
-	http.HandleFunc(webhook(client.CollectionMemberships, client))
-	http.HandleFunc(webhook(client.CollectionMembershipTypes, client))
-	http.HandleFunc(webhook(client.CollectionRelations, client))
-	http.HandleFunc(webhook(client.CollectionRelationTypes, client))
-	http.HandleFunc(webhook(client.Collections, client))
-	http.HandleFunc(webhook(client.CollectionTypes, client))
-	http.HandleFunc(webhook(client.Companies, client))
-	http.HandleFunc(webhook(client.CompanyLogos, client))
-	http.HandleFunc(webhook(client.CompanyStatuses, client))
-	http.HandleFunc(webhook(client.CompanyWebsites, client))
-	http.HandleFunc(webhook(client.Covers, client))
-	http.HandleFunc(webhook(client.DateFormats, client))
-	http.HandleFunc(webhook(client.EventLogos, client))
-	http.HandleFunc(webhook(client.EventNetworks, client))
-	http.HandleFunc(webhook(client.Events, client))
-	http.HandleFunc(webhook(client.ExternalGames, client))
-	http.HandleFunc(webhook(client.ExternalGameSources, client))
-	http.HandleFunc(webhook(client.Franchises, client))
-	http.HandleFunc(webhook(client.GameEngineLogos, client))
-	http.HandleFunc(webhook(client.GameEngines, client))
-	http.HandleFunc(webhook(client.GameLocalizations, client))
-	http.HandleFunc(webhook(client.GameModes, client))
-	http.HandleFunc(webhook(client.GameReleaseFormats, client))
-	http.HandleFunc(webhook(client.Games, client))
-	http.HandleFunc(webhook(client.GameStatuses, client))
-	http.HandleFunc(webhook(client.GameTimeToBeats, client))
-	http.HandleFunc(webhook(client.GameTypes, client))
-	http.HandleFunc(webhook(client.GameVersionFeatures, client))
-	http.HandleFunc(webhook(client.GameVersionFeatureValues, client))
-	http.HandleFunc(webhook(client.GameVersions, client))
-	http.HandleFunc(webhook(client.GameVideos, client))
-	http.HandleFunc(webhook(client.Genres, client))
-	http.HandleFunc(webhook(client.InvolvedCompanies, client))
-	http.HandleFunc(webhook(client.Keywords, client))
-	http.HandleFunc(webhook(client.Languages, client))
-	http.HandleFunc(webhook(client.LanguageSupports, client))
-	http.HandleFunc(webhook(client.LanguageSupportTypes, client))
-	http.HandleFunc(webhook(client.MultiplayerModes, client))
-	http.HandleFunc(webhook(client.NetworkTypes, client))
-	http.HandleFunc(webhook(client.PlatformFamilies, client))
-	http.HandleFunc(webhook(client.PlatformLogos, client))
-	http.HandleFunc(webhook(client.Platforms, client))
-	http.HandleFunc(webhook(client.PlatformTypes, client))
-	http.HandleFunc(webhook(client.PlatformVersionCompanies, client))
-	http.HandleFunc(webhook(client.PlatformVersionReleaseDates, client))
-	http.HandleFunc(webhook(client.PlatformVersions, client))
-	http.HandleFunc(webhook(client.PlatformWebsites, client))
-	http.HandleFunc(webhook(client.PlayerPerspectives, client))
-	http.HandleFunc(webhook(client.PopularityTypes, client))
-	http.HandleFunc(webhook(client.Regions, client))
-	http.HandleFunc(webhook(client.ReleaseDateRegions, client))
-	http.HandleFunc(webhook(client.ReleaseDates, client))
-	http.HandleFunc(webhook(client.ReleaseDateStatuses, client))
-	http.HandleFunc(webhook(client.Screenshots, client))
-	http.HandleFunc(webhook(client.Themes, client))
-	http.HandleFunc(webhook(client.Websites, client))
-	http.HandleFunc(webhook(client.WebsiteTypes, client))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// Server serves the webhooks and the query API.
+type Server struct {
+	db     db.Store
+	client *igdb.Client
+	// processors is filled by registerWebhook before the workers are started
+	// and only read afterwards.
+	processors map[endpoint.Name]*webhookProcessor
+	health     *health
+	mux        *http.ServeMux
+}
+
+// shutdownTimeout is how long in-flight requests may take after shutdown
+// was requested.
+const shutdownTimeout = 30 * time.Second
+
+// StartWebhookServer serves the webhooks and the query API and blocks until
+// ctx is cancelled or the server fails. With register the webhooks are
+// registered with IGDB. On shutdown in-flight requests and webhook events
+// are finished before it returns.
+func StartWebhookServer(ctx context.Context, store db.Store, client *igdb.Client, register bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &Server{
+		db:         store,
+		client:     client,
+		processors: make(map[endpoint.Name]*webhookProcessor),
+		health:     newHealth(register),
+		mux:        http.NewServeMux(),
+	}
+
+	registerWebhook(s, client.AgeRatingCategories)
+	registerWebhook(s, client.AgeRatingContentDescriptions)
+	registerWebhook(s, client.AgeRatingContentDescriptionsV2)
+	registerWebhook(s, client.AgeRatingOrganizations)
+	registerWebhook(s, client.AgeRatings)
+	registerWebhook(s, client.AlternativeNames)
+	registerWebhook(s, client.Artworks)
+	registerWebhook(s, client.CharacterGenders)
+	registerWebhook(s, client.CharacterMugShots)
+	registerWebhook(s, client.Characters)
+	registerWebhook(s, client.CharacterSpecies)
+	registerWebhook(s, client.CollectionMemberships)
+	registerWebhook(s, client.CollectionMembershipTypes)
+	registerWebhook(s, client.CollectionRelations)
+	registerWebhook(s, client.CollectionRelationTypes)
+	registerWebhook(s, client.Collections)
+	registerWebhook(s, client.CollectionTypes)
+	registerWebhook(s, client.Companies)
+	registerWebhook(s, client.CompanyLogos)
+	registerWebhook(s, client.CompanyStatuses)
+	registerWebhook(s, client.CompanyWebsites)
+	registerWebhook(s, client.Covers)
+	registerWebhook(s, client.DateFormats)
+	registerWebhook(s, client.EventLogos)
+	registerWebhook(s, client.EventNetworks)
+	registerWebhook(s, client.Events)
+	registerWebhook(s, client.ExternalGames)
+	registerWebhook(s, client.ExternalGameSources)
+	registerWebhook(s, client.Franchises)
+	registerWebhook(s, client.GameEngineLogos)
+	registerWebhook(s, client.GameEngines)
+	registerWebhook(s, client.GameLocalizations)
+	registerWebhook(s, client.GameModes)
+	registerWebhook(s, client.GameReleaseFormats)
+	registerWebhook(s, client.Games)
+	registerWebhook(s, client.GameStatuses)
+	registerWebhook(s, client.GameTimeToBeats)
+	registerWebhook(s, client.GameTypes)
+	registerWebhook(s, client.GameVersionFeatures)
+	registerWebhook(s, client.GameVersionFeatureValues)
+	registerWebhook(s, client.GameVersions)
+	registerWebhook(s, client.GameVideos)
+	registerWebhook(s, client.Genres)
+	registerWebhook(s, client.InvolvedCompanies)
+	registerWebhook(s, client.Keywords)
+	registerWebhook(s, client.Languages)
+	registerWebhook(s, client.LanguageSupports)
+	registerWebhook(s, client.LanguageSupportTypes)
+	registerWebhook(s, client.MultiplayerModes)
+	registerWebhook(s, client.NetworkTypes)
+	registerWebhook(s, client.PlatformFamilies)
+	registerWebhook(s, client.PlatformLogos)
+	registerWebhook(s, client.Platforms)
+	registerWebhook(s, client.PlatformTypes)
+	registerWebhook(s, client.PlatformVersionCompanies)
+	registerWebhook(s, client.PlatformVersionReleaseDates)
+	registerWebhook(s, client.PlatformVersions)
+	registerWebhook(s, client.PlatformWebsites)
+	registerWebhook(s, client.PlayerPerspectives)
+	registerWebhook(s, client.PopularityTypes)
+	registerWebhook(s, client.Regions)
+	registerWebhook(s, client.ReleaseDateRegions)
+	registerWebhook(s, client.ReleaseDates)
+	registerWebhook(s, client.ReleaseDateStatuses)
+	registerWebhook(s, client.Screenshots)
+	registerWebhook(s, client.Themes)
+	registerWebhook(s, client.Websites)
+	registerWebhook(s, client.WebsiteTypes)
+	s.registerAPI(s.mux)
+	s.registerQueueAPI(s.mux)
+	s.registerHealthAPI(s.mux)
+	metrics.RegisterStore(s.db)
+	s.mux.Handle("/metrics", metrics.Handler())
+	s.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(200)
 		if _, err := w.Write([]byte("Hello World!")); err != nil {
-			log.Printf("failed to write response: %v", err)
+			slog.Error("failed to write response", logging.Err(err))
 		}
 	})
 
-	serverStart := make(chan bool)
+	workers := s.startWebhookWorkers(ctx, webhookWorkerNum)
+
+	server := &http.Server{Addr: config.C().Address, Handler: s.mux}
+	serverErr := make(chan error, 1)
 	go func() {
-		defer close(serverStart)
-		log.Printf("starting webhook server on %s", config.C().Address)
-		err = http.ListenAndServe(config.C().Address, nil)
-		if err != nil {
-			log.Fatalf("failed to start webhook server: %v", err)
-		}
+		slog.Info("starting webhook server", "address", config.C().Address)
+		serverErr <- server.ListenAndServe()
 	}()
 
-	enabledEndpoint := endpoint.AllNames
-	enabledEndpoint = slices.DeleteFunc(enabledEndpoint, func(e endpoint.Name) bool {
-		return e == endpoint.EPWebhooks || e == endpoint.EPSearch || e == endpoint.EPPopularityPrimitives
-	})
+	var err error
+	if register {
+		err = RegisterWebhooks(ctx, client)
+		if err == nil {
+			s.health.setRegistered()
+		}
+	}
+	if err == nil {
+		select {
+		case err = <-serverErr:
+			err = fmt.Errorf("failed to start webhook server: %w", err)
+		case <-ctx.Done():
+		}
+	}
+
+	slog.Info("shutting down webhook server")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancelShutdown()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("failed to shut down webhook server", logging.Err(err))
+	}
+	cancel()
+	// events are cancelled shutdownTimeout after the workers were stopped,
+	// leave them a moment to return
+	if !waitForWorkers(workers, shutdownTimeout+5*time.Second) {
+		slog.Warn("webhook workers did not stop, abandoning their events")
+	}
+	slog.Info("webhook server stopped")
+	return err
+}
+
+// RegisterWebhooks registers the create, update and delete webhooks of every
+// endpoint with IGDB. Nothing is registered if the external url is on
+// localhost, unless a fake IGDB is used. It stops between endpoints when
+// ctx is cancelled.
+func RegisterWebhooks(ctx context.Context, client *igdb.Client) error {
+	baseUrl, err := url.Parse(config.C().ExternalUrl)
+	if err != nil {
+		return fmt.Errorf("failed to parse url: %w", err)
+	}
 
 	ip := net.ParseIP(baseUrl.Hostname())
-	if baseUrl.Hostname() == "localhost" || (ip != nil && ip.IsLoopback()) {
-		log.Printf("extral url is localhost. webhook will not be registered")
-	} else {
-		for _, ep := range enabledEndpoint {
-			Url := baseUrl.JoinPath(fmt.Sprintf("/webhook/%s", string(ep)))
-			log.Printf("registering webhook \"%s\" to \"%s\"", ep, Url.String())
-			_, err = client.Webhooks.Register(ep, config.C().WebhookSecret, Url.String(), endpoint.WebhookMethodCreate)
-			if err != nil {
-				log.Fatalf("failed to register webhook \"%s\": %v", ep, err)
-			}
-			_, err = client.Webhooks.Register(ep, config.C().WebhookSecret, Url.String(), endpoint.WebhookMethodUpdate)
-			if err != nil {
-				log.Fatalf("failed to register webhook \"%s\": %v", ep, err)
-			}
-			log.Printf("webhook \"%s\" registered", ep)
+	// a fake igdb can call webhooks on localhost
+	isLocal := baseUrl.Hostname() == "localhost" || (ip != nil && ip.IsLoopback())
+	if isLocal && config.C().IGDB.Proxy == "" {
+		slog.Warn("external url is localhost, webhooks will not be registered")
+		return nil
+	}
+
+	for _, ep := range WebhookEndpoints() {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("webhook registration interrupted: %w", err)
+		}
+		Url := baseUrl.JoinPath(fmt.Sprintf("/webhook/%s", string(ep)))
+		slog.Debug("registering webhook", logging.KeyEndpoint, ep, "url", Url.String())
+		_, err = client.Webhooks.Register(ep, config.C().WebhookSecret, Url.String(), endpoint.WebhookMethodCreate)
+		if err != nil {
+			return fmt.Errorf("failed to register webhook \"%s\": %w", ep, err)
+		}
+		_, err = client.Webhooks.Register(ep, config.C().WebhookSecret, Url.String(), endpoint.WebhookMethodUpdate)
+		if err != nil {
+			return fmt.Errorf("failed to register webhook \"%s\": %w", ep, err)
+		}
+		deleteUrl := Url.JoinPath("delete")
+		_, err = client.Webhooks.Register(ep, config.C().WebhookSecret, deleteUrl.String(), endpoint.WebhookMethodDelete)
+		if err != nil {
+			return fmt.Errorf("failed to register webhook \"%s\": %w", ep, err)
 		}
-		log.Printf("all webhook registered")
+		slog.Info("webhook registered", logging.KeyEndpoint, ep)
 	}
+	slog.Info("all webhooks registered")
+	return nil
+}
 
-	<-serverStart
+// WebhookEndpoints returns the endpoints webhooks are registered for.
+func WebhookEndpoints() []endpoint.Name {
+	return slices.DeleteFunc(slices.Clone(endpoint.AllNames), func(e endpoint.Name) bool {
+		return e == endpoint.EPWebhooks || e == endpoint.EPSearch || e == endpoint.EPPopularityPrimitives
+	})
+}
+
+// webhookProcessor applies the webhook events of one endpoint and counts its
+// items on IGDB for the status page.
+type webhookProcessor struct {
+	upsert func(ctx context.Context, id uint64) error
+	remove func(ctx context.Context, id uint64) error
+	count  func() (uint64, error)
 }
 
-func webhook[T any](
+func registerWebhook[T any](
+	s *Server,
 	e endpoint.EntityEndpoint[T],
-	client *igdb.Client,
-) (string, func(w http.ResponseWriter, r *http.Request)) {
-	return fmt.Sprintf("/webhook/%s", e.GetEndpointName()), func(w http.ResponseWriter, r *http.Request) {
+) {
+	name := e.GetEndpointName()
+	s.processors[name] = &webhookProcessor{
+		upsert: upsertItem(s, e),
+		remove: func(ctx context.Context, id uint64) error {
+			return s.removeItem(ctx, name, id)
+		},
+		count: func() (uint64, error) {
+			start := time.Now()
+			count, err := e.Count()
+			metrics.IgdbRequest(string(name), "count", start, err)
+			return count, err
+		},
+	}
+	s.mux.HandleFunc(fmt.Sprintf("/webhook/%s", name), s.webhook(name, model.WebhookMethodUpsert))
+	s.mux.HandleFunc(fmt.Sprintf("/webhook/%s/delete", name), s.webhook(name, model.WebhookMethodDelete))
+}
+
+// webhook only stores the received event in the queue, it is applied by the
+// webhook workers. IGDB retries the call if the event could not be stored.
+func (s *Server) webhook(
+	name endpoint.Name,
+	method model.WebhookMethod,
+) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get("X-Request-Id")
+		if requestId == "" {
+			requestId = logging.NewRequestId()
+		}
+		w.Header().Set("X-Request-Id", requestId)
+		logger := slog.With(logging.KeyRequestId, requestId, logging.KeyEndpoint, name, "method", method)
+
 		secret := r.Header.Get("X-Secret")
 		if secret != config.C().WebhookSecret {
 			w.WriteHeader(401)
 			return
 		}
-		w.WriteHeader(200)
 		data := struct {
 			ID uint64 `json:"id"`
 		}{}
 		jsonBytes, err := io.ReadAll(r.Body)
 		if err != nil {
-			log.Printf("failed to read request body: %v", err)
+			logger.Warn("failed to read request body", logging.Err(err))
+			w.WriteHeader(400)
 			return
 		}
 		err = json.Unmarshal(jsonBytes, &data)
 		if err != nil {
-			log.Printf("failed to unmarshal request body: %v", err)
+			logger.Warn("failed to unmarshal request body", logging.Err(err))
+			w.WriteHeader(400)
 			return
 		}
 		if data.ID == 0 {
+			w.WriteHeader(200)
 			return
 		}
-		item, err := e.GetByID(data.ID)
+
+		logger = logger.With(logging.KeyEntityId, data.ID)
+		err = s.db.EnqueueWebhookEvent(r.Context(), &model.WebhookEvent{
+			Endpoint:  name,
+			Method:    method,
+			EntityId:  data.ID,
+			RequestId: requestId,
+		})
 		if err != nil {
-			log.Printf("failed to get %s: %v", e.GetEndpointName(), err)
+			logger.Error("failed to enqueue webhook event", logging.Err(err))
+			w.WriteHeader(500)
 			return
 		}
+		logger.Debug("webhook event queued")
+		metrics.WebhookReceived(string(name), string(method))
+		s.health.webhookReceived(name)
+		w.WriteHeader(200)
+	}
+}
 
-		if _, ok := any(e).(*endpoint.Games); ok {
-			game := any(item).(*pb.Game)
-			g, err := db.ConvertGame(game, client)
-			if err != nil {
-				log.Printf("failed to convert game: %v", err)
-			} else {
-				_ = db.SaveGame(g)
-				log.Printf("game %d aggregated", data.ID)
-			}
-		}
-
-		err = db.SaveItem(e.GetEndpointName(), item)
+func upsertItem[T any](
+	s *Server,
+	e endpoint.EntityEndpoint[T],
+) func(ctx context.Context, id uint64) error {
+	return func(ctx context.Context, id uint64) error {
+		logger := logging.From(ctx)
+		start := time.Now()
+		item, err := e.GetByID(id)
+		metrics.IgdbRequest(string(e.GetEndpointName()), "get_by_id", start, err)
 		if err != nil {
-			log.Printf("failed to save %s: %v", e.GetEndpointName(), err)
-			return
+			return fmt.Errorf("failed to get %s %d: %w", e.GetEndpointName(), id, err)
 		}
+		logger.Debug("item fetched from igdb", "duration", time.Since(start))
+		return s.storeItem(ctx, e.GetEndpointName(), any(item).(db.IdGetter))
+	}
+}
 
-		// update associated game
-		type gameGetter interface {
-			GetGame() *pb.Game
-		}
+// storeItem saves an item fetched for a webhook event and updates the
+// aggregated game it belongs to or the copies embedded elsewhere.
+func (s *Server) storeItem(ctx context.Context, name endpoint.Name, item db.IdGetter) error {
+	err := s.db.SaveItem(ctx, name, item)
+	if err != nil {
+		return fmt.Errorf("failed to save %s %d: %w", name, item.GetId(), err)
+	}
+	logging.From(ctx).Info("item saved")
 
-		if v, ok := any(item).(gameGetter); ok {
-			game, err := db.GetItemById[pb.Game](endpoint.EPGames, v.GetGame().Id)
-			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
-				log.Printf("failed to get game: %v", err)
-				goto END
-			}
-			g, err := db.ConvertGame(game, client)
-			if err != nil {
-				log.Printf("failed to convert game: %v", err)
-				goto END
-			}
-			err = db.SaveGame(g)
+	// update associated game
+	if gameId, ok := affectedGameId(item); ok {
+		return AggregateGame(ctx, s.db, gameId, s.client)
+	}
+	err = patchEmbedded(ctx, s.db, name, item)
+	if err != nil {
+		return err
+	}
+	return RefreshViews(ctx, s.db, s.client, name, []uint64{item.GetId()})
+}
+
+func (s *Server) removeItem(ctx context.Context, name endpoint.Name, id uint64) error {
+	err := s.db.RemoveItem(ctx, name, id)
+	if err != nil {
+		return err
+	}
+	err = s.db.RemoveFromGames(ctx, name, id)
+	if err != nil {
+		return err
+	}
+	for _, v := range db.Views {
+		if v.EndpointName() == name {
+			err = s.db.RemoveViewDocument(ctx, v.Name(), id)
 			if err != nil {
-				log.Printf("failed to save game: %v", err)
-				goto END
+				return err
 			}
-			log.Printf("game %d aggregated", data.ID)
 		}
-
-	END:
-		log.Printf("%s %d saved", e.GetEndpointName(), data.ID)
 	}
+	// the removed item is not fetched again, views keep its id only
+	err = RefreshViews(ctx, s.db, nil, name, []uint64{id})
+	if err != nil {
+		return err
+	}
+	logging.From(ctx).Info("item removed")
+	return nil
 }
@@ -0,0 +1,161 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/config"
+	"igdb-database/db"
+	"igdb-database/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+)
+
+const testSecret = "secret"
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("IGDB_STORAGE", "memory")
+	t.Setenv("IGDB_TWITCH_CLIENT_ID", "id")
+	t.Setenv("IGDB_TWITCH_CLIENT_SECRET", "secret")
+	t.Setenv("IGDB_WEBHOOK_SECRET", testSecret)
+	if _, err := config.Load(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestServer returns a server on a memory store whose genres webhooks
+// take the genre from igdb instead of fetching it.
+func newTestServer(igdb map[uint64]*pb.Genre) *Server {
+	s := &Server{
+		db:         db.NewMemoryStore(),
+		processors: make(map[endpoint.Name]*webhookProcessor),
+		health:     newHealth(false),
+		mux:        http.NewServeMux(),
+	}
+	s.processors[endpoint.EPGenres] = &webhookProcessor{
+		upsert: func(ctx context.Context, id uint64) error {
+			genre, ok := igdb[id]
+			if !ok {
+				return fmt.Errorf("genre %d not found", id)
+			}
+			return s.storeItem(ctx, endpoint.EPGenres, genre)
+		},
+		remove: func(ctx context.Context, id uint64) error {
+			return s.removeItem(ctx, endpoint.EPGenres, id)
+		},
+	}
+	s.mux.HandleFunc("/webhook/genres", s.webhook(endpoint.EPGenres, model.WebhookMethodUpsert))
+	s.mux.HandleFunc("/webhook/genres/delete", s.webhook(endpoint.EPGenres, model.WebhookMethodDelete))
+	return s
+}
+
+func (s *Server) callWebhook(t *testing.T, path string, id uint64) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(fmt.Sprintf(`{"id": %d}`, id)))
+	req.Header.Set("X-Secret", testSecret)
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("webhook %s returned %d", path, rec.Code)
+	}
+}
+
+// drainQueue processes the queued events like a worker does.
+func (s *Server) drainQueue(t *testing.T) {
+	t.Helper()
+	ctx := context.Background()
+	for {
+		event, err := s.db.ClaimWebhookEvent(ctx, webhookLockDuration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event == nil {
+			return
+		}
+		s.processWebhookEvent(ctx, event)
+	}
+}
+
+func TestWebhookUpsertAndDelete(t *testing.T) {
+	loadTestConfig(t)
+	ctx := context.Background()
+	igdb := map[uint64]*pb.Genre{10: {Id: 10, Name: "Adventure"}}
+	s := newTestServer(igdb)
+	err := db.SaveItem(ctx, s.db, endpoint.EPGames, &pb.Game{Id: 1, Name: "Zelda", Genres: []*pb.Genre{{Id: 10}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.SaveItem(ctx, s.db, endpoint.EPGenres, igdb[10])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AggregateGame(ctx, s.db, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	igdb[10] = &pb.Genre{Id: 10, Name: "Action"}
+	s.callWebhook(t, "/webhook/genres", 10)
+	s.callWebhook(t, "/webhook/genres", 10)
+	if queued, _ := s.db.CountWebhookEvents(ctx); queued != 1 {
+		t.Errorf("queued events = %d, want the calls for one genre coalesced to 1", queued)
+	}
+	s.drainQueue(t)
+
+	game, err := s.db.GetGameById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Genres) != 1 || game.Genres[0].Name != "Action" {
+		t.Errorf("genres after update = %v, want Action", game.Genres)
+	}
+
+	s.callWebhook(t, "/webhook/genres/delete", 10)
+	s.drainQueue(t)
+
+	game, err = s.db.GetGameById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Genres) != 0 {
+		t.Errorf("genres after delete = %v, want none", game.Genres)
+	}
+	if _, err := s.db.GetItemById(ctx, endpoint.EPGenres, 10); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("get deleted genre: err = %v, want ErrNotFound", err)
+	}
+	if queued, _ := s.db.CountWebhookEvents(ctx); queued != 0 {
+		t.Errorf("queued events = %d, want 0", queued)
+	}
+}
+
+func TestWebhookEventRequeuedWhileProcessing(t *testing.T) {
+	loadTestConfig(t)
+	ctx := context.Background()
+	s := newTestServer(map[uint64]*pb.Genre{10: {Id: 10, Name: "Adventure"}})
+
+	s.callWebhook(t, "/webhook/genres", 10)
+	event, err := s.db.ClaimWebhookEvent(ctx, webhookLockDuration)
+	if err != nil || event == nil {
+		t.Fatalf("claim: event %v, err %v", event, err)
+	}
+	// a delete arriving while the upsert is applied is applied after it
+	s.callWebhook(t, "/webhook/genres/delete", 10)
+	s.processWebhookEvent(ctx, event)
+
+	next, err := s.db.ClaimWebhookEvent(ctx, webhookLockDuration)
+	if err != nil || next == nil {
+		t.Fatalf("claim after requeue: event %v, err %v", next, err)
+	}
+	if next.Method != model.WebhookMethodDelete {
+		t.Errorf("requeued method = %s, want delete", next.Method)
+	}
+	s.processWebhookEvent(ctx, next)
+	if _, err := s.db.GetItemById(ctx, endpoint.EPGenres, 10); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("get deleted genre: err = %v, want ErrNotFound", err)
+	}
+}
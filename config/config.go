@@ -2,41 +2,455 @@ package config
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Address  string `json:"address"`
+	Address string `json:"address"`
+	// Storage is either "mongodb" (default) or "memory".
+	Storage  string `json:"storage"`
 	Database struct {
-		Host     string `json:"host"`
-		Port     int    `json:"port"`
-		User     string `json:"user"`
-		Password string `json:"password"`
-		Database string `json:"database"`
+		// URI is a full connection string, e.g. mongodb+srv://. The options
+		// below are applied on top of it.
+		URI     string `json:"uri"`
+		URIFile string `json:"uri_file"`
+		Host    string `json:"host"`
+		Port    int    `json:"port"`
+		// Hosts lists host:port pairs, e.g. the members of a replica set.
+		Hosts        []string `json:"hosts"`
+		ReplicaSet   string   `json:"replica_set"`
+		User         string   `json:"user"`
+		Password     string   `json:"password"`
+		PasswordFile string   `json:"password_file"`
+		AuthSource   string   `json:"auth_source"`
+		Database     string   `json:"database"`
+		TLS          struct {
+			Enabled bool   `json:"enabled"`
+			CAFile  string `json:"ca_file"`
+			// CertFile and KeyFile are the client certificate, KeyFile can
+			// be omitted if CertFile holds both.
+			CertFile           string `json:"cert_file"`
+			KeyFile            string `json:"key_file"`
+			InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+		} `json:"tls"`
+		ReadPreference         string   `json:"read_preference"`
+		MaxPoolSize            uint64   `json:"max_pool_size"`
+		MinPoolSize            uint64   `json:"min_pool_size"`
+		ConnectTimeout         Duration `json:"connect_timeout"`
+		ServerSelectionTimeout Duration `json:"server_selection_timeout"`
+		// Timeout limits operations that are not limited by their caller.
+		Timeout Duration `json:"timeout"`
 	} `json:"database"`
 	Twitch struct {
-		ClientID     string `json:"client_id"`
-		ClientSecret string `json:"client_secret"`
+		ClientID         string `json:"client_id"`
+		ClientSecret     string `json:"client_secret"`
+		ClientSecretFile string `json:"client_secret_file"`
 	} `json:"twitch"`
-	WebhookSecret string `json:"webhook_secret"`
-	ExternalUrl   string `json:"external_url"`
+	// IGDB points the client at a fake IGDB, see cmd/fake-igdb.
+	IGDB struct {
+		Proxy  string `json:"proxy"`
+		CAFile string `json:"ca_file"`
+	} `json:"igdb"`
+	WebhookSecret     string `json:"webhook_secret"`
+	WebhookSecretFile string `json:"webhook_secret_file"`
+	ExternalUrl       string `json:"external_url"`
+	Aggregation       struct {
+		// ExpandDepth is how many levels of references inside the items
+		// embedded in aggregated games are replaced by the referenced items,
+		// e.g. 2 embeds the company of an involved company and the logo of
+		// that company. Unset means DefaultExpandDepth, 0 disables it.
+		ExpandDepth *int `json:"expand_depth"`
+		// RelatedSummaries embeds a summary of every related game, e.g.
+		// the similar games and DLCs, next to their ids.
+		RelatedSummaries bool `json:"related_summaries"`
+	} `json:"aggregation"`
+	Log struct {
+		// Level is debug, info (default), warn or error.
+		Level string `json:"level"`
+		// Format is text (default) or json.
+		Format string `json:"format"`
+	} `json:"log"`
 }
 
-var c *Config
+const (
+	DefaultExpandDepth = 2
+	MaxExpandDepth     = 3
+)
 
-func init() {
-	jsonBytes, err := os.ReadFile("config.json")
-	if err != nil {
-		log.Fatalf("failed to read config.json: %v", err)
+// ExpandDepth returns aggregation.expand_depth or its default.
+func (cfg *Config) ExpandDepth() int {
+	if cfg.Aggregation.ExpandDepth == nil {
+		return DefaultExpandDepth
+	}
+	return *cfg.Aggregation.ExpandDepth
+}
+
+// Duration is a time.Duration written as a string like "10s".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"10s\"")
 	}
-	c = &Config{}
-	err = json.Unmarshal(jsonBytes, c)
+	v, err := time.ParseDuration(s)
 	if err != nil {
-		log.Fatalf("failed to unmarshal config.json: %v", err)
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+var c *Config
+
+// Load reads the configuration from the JSON or YAML file at path, or only
+// from the environment if path is empty, and validates it. Every option can
+// be overridden by an environment variable, see envVars, and every variable
+// X can be read from the file named by X_FILE instead.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config: %w", err)
+		}
+		if err := unmarshal(path, data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
 	}
+
+	// secret files are part of the file config, so the environment
+	// overrides them too
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	c = cfg
+	return cfg, nil
 }
 
+// C returns the configuration loaded by Load.
 func C() *Config {
+	if c == nil {
+		panic("config is not loaded")
+	}
 	return c
 }
+
+// unmarshal decodes JSON, or YAML for .yaml and .yml files. YAML is converted
+// to JSON first, so both use the json tags.
+func unmarshal(path string, data []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var v any
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return err
+		}
+		var err error
+		data, err = json.Marshal(v)
+		if err != nil {
+			return err
+		}
+	}
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.DisallowUnknownFields()
+	return dec.Decode(cfg)
+}
+
+type envVar struct {
+	name string
+	set  func(value string) error
+}
+
+func (cfg *Config) envVars() []envVar {
+	str := func(p *string) func(string) error {
+		return func(value string) error {
+			*p = value
+			return nil
+		}
+	}
+	number := func(p *uint64) func(string) error {
+		return func(value string) error {
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			*p = v
+			return nil
+		}
+	}
+	boolean := func(p *bool) func(string) error {
+		return func(value string) error {
+			v, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a boolean", value)
+			}
+			*p = v
+			return nil
+		}
+	}
+	duration := func(p *Duration) func(string) error {
+		return func(value string) error {
+			v, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			*p = Duration(v)
+			return nil
+		}
+	}
+	return []envVar{
+		{"IGDB_ADDRESS", str(&cfg.Address)},
+		{"IGDB_STORAGE", str(&cfg.Storage)},
+		{"IGDB_DB_URI", str(&cfg.Database.URI)},
+		{"IGDB_DB_HOST", str(&cfg.Database.Host)},
+		{"IGDB_DB_PORT", func(value string) error {
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid port %q", value)
+			}
+			cfg.Database.Port = port
+			return nil
+		}},
+		{"IGDB_DB_HOSTS", func(value string) error {
+			cfg.Database.Hosts = strings.Split(value, ",")
+			return nil
+		}},
+		{"IGDB_DB_REPLICA_SET", str(&cfg.Database.ReplicaSet)},
+		{"IGDB_DB_USER", str(&cfg.Database.User)},
+		{"IGDB_DB_PASSWORD", str(&cfg.Database.Password)},
+		{"IGDB_DB_AUTH_SOURCE", str(&cfg.Database.AuthSource)},
+		{"IGDB_DB_DATABASE", str(&cfg.Database.Database)},
+		{"IGDB_DB_TLS", boolean(&cfg.Database.TLS.Enabled)},
+		{"IGDB_DB_TLS_CA_FILE", str(&cfg.Database.TLS.CAFile)},
+		{"IGDB_DB_TLS_CERT_FILE", str(&cfg.Database.TLS.CertFile)},
+		{"IGDB_DB_TLS_KEY_FILE", str(&cfg.Database.TLS.KeyFile)},
+		{"IGDB_DB_READ_PREFERENCE", str(&cfg.Database.ReadPreference)},
+		{"IGDB_DB_MAX_POOL_SIZE", number(&cfg.Database.MaxPoolSize)},
+		{"IGDB_DB_MIN_POOL_SIZE", number(&cfg.Database.MinPoolSize)},
+		{"IGDB_DB_CONNECT_TIMEOUT", duration(&cfg.Database.ConnectTimeout)},
+		{"IGDB_DB_SERVER_SELECTION_TIMEOUT", duration(&cfg.Database.ServerSelectionTimeout)},
+		{"IGDB_DB_TIMEOUT", duration(&cfg.Database.Timeout)},
+		{"IGDB_TWITCH_CLIENT_ID", str(&cfg.Twitch.ClientID)},
+		{"IGDB_TWITCH_CLIENT_SECRET", str(&cfg.Twitch.ClientSecret)},
+		{"IGDB_WEBHOOK_SECRET", str(&cfg.WebhookSecret)},
+		{"IGDB_EXTERNAL_URL", str(&cfg.ExternalUrl)},
+		{"IGDB_PROXY", str(&cfg.IGDB.Proxy)},
+		{"IGDB_CA_FILE", str(&cfg.IGDB.CAFile)},
+		{"IGDB_EXPAND_DEPTH", func(value string) error {
+			depth, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			cfg.Aggregation.ExpandDepth = &depth
+			return nil
+		}},
+		{"IGDB_RELATED_SUMMARIES", boolean(&cfg.Aggregation.RelatedSummaries)},
+		{"IGDB_LOG_LEVEL", str(&cfg.Log.Level)},
+		{"IGDB_LOG_FORMAT", str(&cfg.Log.Format)},
+	}
+}
+
+func (cfg *Config) applyEnv() error {
+	for _, v := range cfg.envVars() {
+		value, ok := os.LookupEnv(v.name)
+		if file, fileOk := os.LookupEnv(v.name + "_FILE"); fileOk {
+			if ok {
+				return fmt.Errorf("only one of %s and %s_FILE can be set", v.name, v.name)
+			}
+			var err error
+			value, err = readSecret(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s_FILE: %w", v.name, err)
+			}
+			ok = true
+		}
+		if !ok {
+			continue
+		}
+		if err := v.set(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+// readSecretFiles reads the secrets configured as files, e.g. Docker or
+// Kubernetes secrets.
+func (cfg *Config) readSecretFiles() error {
+	secrets := []struct {
+		name   string
+		file   string
+		secret *string
+	}{
+		{"database.uri_file", cfg.Database.URIFile, &cfg.Database.URI},
+		{"database.password_file", cfg.Database.PasswordFile, &cfg.Database.Password},
+		{"twitch.client_secret_file", cfg.Twitch.ClientSecretFile, &cfg.Twitch.ClientSecret},
+		{"webhook_secret_file", cfg.WebhookSecretFile, &cfg.WebhookSecret},
+	}
+	for _, s := range secrets {
+		if s.file == "" {
+			continue
+		}
+		value, err := readSecret(s.file)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.name, err)
+		}
+		*s.secret = value
+	}
+	return nil
+}
+
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// Validate checks the options needed by every command.
+func (cfg *Config) Validate() error {
+	errs := []error{}
+	required := func(value string, name string, env string) {
+		if value == "" {
+			errs = append(errs, fmt.Errorf("%s is required (or set %s)", name, env))
+		}
+	}
+
+	required(cfg.Twitch.ClientID, "twitch.client_id", "IGDB_TWITCH_CLIENT_ID")
+	required(cfg.Twitch.ClientSecret, "twitch.client_secret", "IGDB_TWITCH_CLIENT_SECRET")
+
+	switch cfg.Storage {
+	case "", "mongodb":
+		errs = append(errs, cfg.validateDatabase()...)
+	case "memory":
+	default:
+		errs = append(errs, fmt.Errorf("storage %q is not mongodb or memory", cfg.Storage))
+	}
+
+	if cfg.Address != "" {
+		if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+			errs = append(errs, fmt.Errorf("address %q is not host:port", cfg.Address))
+		}
+	}
+	if cfg.ExternalUrl != "" {
+		if err := validateUrl(cfg.ExternalUrl); err != nil {
+			errs = append(errs, fmt.Errorf("external_url: %w", err))
+		}
+	}
+	if cfg.IGDB.Proxy != "" {
+		if err := validateUrl(cfg.IGDB.Proxy); err != nil {
+			errs = append(errs, fmt.Errorf("igdb.proxy: %w", err))
+		}
+	}
+	if depth := cfg.ExpandDepth(); depth < 0 || depth > MaxExpandDepth {
+		errs = append(errs, fmt.Errorf("aggregation.expand_depth %d is not between 0 and %d", depth, MaxExpandDepth))
+	}
+	switch strings.ToLower(cfg.Log.Level) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level %q is not debug, info, warn or error", cfg.Log.Level))
+	}
+	switch strings.ToLower(cfg.Log.Format) {
+	case "", "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format %q is not text or json", cfg.Log.Format))
+	}
+	return errors.Join(errs...)
+}
+
+func (cfg *Config) validateDatabase() []error {
+	d := cfg.Database
+	errs := []error{}
+	if d.Database == "" {
+		errs = append(errs, fmt.Errorf("database.database is required (or set IGDB_DB_DATABASE)"))
+	}
+
+	switch {
+	case d.URI != "":
+		if !strings.HasPrefix(d.URI, "mongodb://") && !strings.HasPrefix(d.URI, "mongodb+srv://") {
+			// The uri may hold a password, so it is not part of the error.
+			errs = append(errs, fmt.Errorf("database.uri must start with mongodb:// or mongodb+srv://"))
+		}
+		if d.Host != "" || len(d.Hosts) > 0 {
+			errs = append(errs, fmt.Errorf("database.uri cannot be combined with database.host or database.hosts"))
+		}
+	case len(d.Hosts) > 0:
+		if d.Host != "" {
+			errs = append(errs, fmt.Errorf("only one of database.host and database.hosts can be set"))
+		}
+		for _, host := range d.Hosts {
+			if _, _, err := net.SplitHostPort(host); err != nil {
+				errs = append(errs, fmt.Errorf("database.hosts: %q is not host:port", host))
+			}
+		}
+	case d.Host == "":
+		errs = append(errs, fmt.Errorf("database.host, database.hosts or database.uri is required (or set IGDB_DB_HOST)"))
+	}
+	if d.Port < 0 || d.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database.port %d is not between 1 and 65535", d.Port))
+	}
+
+	if d.TLS.KeyFile != "" && d.TLS.CertFile == "" {
+		errs = append(errs, fmt.Errorf("database.tls.key_file requires database.tls.cert_file"))
+	}
+	switch strings.ToLower(d.ReadPreference) {
+	case "", "primary", "primarypreferred", "secondary", "secondarypreferred", "nearest":
+	default:
+		errs = append(errs, fmt.Errorf("database.read_preference %q is not primary, primaryPreferred, secondary, secondaryPreferred or nearest", d.ReadPreference))
+	}
+	if d.MaxPoolSize > 0 && d.MinPoolSize > d.MaxPoolSize {
+		errs = append(errs, fmt.Errorf("database.min_pool_size %d is larger than database.max_pool_size %d", d.MinPoolSize, d.MaxPoolSize))
+	}
+	if d.ConnectTimeout < 0 || d.ServerSelectionTimeout < 0 || d.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("database timeouts cannot be negative"))
+	}
+	return errs
+}
+
+// ValidateServer checks the additional options needed by the webhook server.
+func (cfg *Config) ValidateServer() error {
+	errs := []error{}
+	if cfg.Address == "" {
+		errs = append(errs, fmt.Errorf("address is required (or set IGDB_ADDRESS)"))
+	}
+	if cfg.ExternalUrl == "" {
+		errs = append(errs, fmt.Errorf("external_url is required (or set IGDB_EXTERNAL_URL)"))
+	}
+	if cfg.WebhookSecret == "" {
+		errs = append(errs, fmt.Errorf("webhook_secret is required (or set IGDB_WEBHOOK_SECRET)"))
+	}
+	return errors.Join(errs...)
+}
+
+func validateUrl(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https url", s)
+	}
+	return nil
+}
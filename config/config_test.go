@@ -0,0 +1,206 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clearEnv unsets the variables of the environment that configure the
+// service for the duration of the test.
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(name, "IGDB_") {
+			t.Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testYAML = `
+address: localhost:8080
+database:
+  host: localhost
+  port: 27017
+  user: igdb
+  password: file-password
+  database: igdb
+  timeout: 10s
+twitch:
+  client_id: id
+  client_secret: secret
+aggregation:
+  expand_depth: 1
+log:
+  level: debug
+`
+
+func TestLoadYAML(t *testing.T) {
+	clearEnv(t)
+	cfg, err := Load(writeFile(t, "config.yaml", testYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "localhost:8080" || cfg.Database.Host != "localhost" || cfg.Database.Port != 27017 {
+		t.Errorf("address %q, database %s:%d, want localhost:8080 and localhost:27017", cfg.Address, cfg.Database.Host, cfg.Database.Port)
+	}
+	if time.Duration(cfg.Database.Timeout) != 10*time.Second {
+		t.Errorf("database.timeout = %v, want 10s", time.Duration(cfg.Database.Timeout))
+	}
+	if cfg.ExpandDepth() != 1 {
+		t.Errorf("expand depth = %d, want 1", cfg.ExpandDepth())
+	}
+	if C() != cfg {
+		t.Error("C() does not return the loaded config")
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.json", `{
+		"storage": "memory",
+		"twitch": {"client_id": "id", "client_secret": "secret"},
+		"aggregation": {"related_summaries": true}
+	}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Storage != "memory" || !cfg.Aggregation.RelatedSummaries {
+		t.Errorf("storage %q, related summaries %v, want memory and true", cfg.Storage, cfg.Aggregation.RelatedSummaries)
+	}
+	if cfg.ExpandDepth() != DefaultExpandDepth {
+		t.Errorf("expand depth = %d, want the default %d", cfg.ExpandDepth(), DefaultExpandDepth)
+	}
+}
+
+func TestLoadUnknownField(t *testing.T) {
+	clearEnv(t)
+	tests := map[string]string{
+		"config.json": `{"storage": "memory", "twitch": {"client_id": "id", "client_secret": "secret", "token": "x"}}`,
+		"config.yaml": "storage: memory\nadress: localhost:8080\n",
+	}
+	for name, content := range tests {
+		if _, err := Load(writeFile(t, name, content)); err == nil || !strings.Contains(err.Error(), "unknown field") {
+			t.Errorf("%s: err = %v, want an unknown field error", name, err)
+		}
+	}
+}
+
+func TestLoadEnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", testYAML)
+	t.Setenv("IGDB_DB_PASSWORD", "env-password")
+	t.Setenv("IGDB_DB_PORT", "27018")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Password != "env-password" || cfg.Database.Port != 27018 {
+		t.Errorf("password %q, port %d, want the env values", cfg.Database.Password, cfg.Database.Port)
+	}
+}
+
+func TestLoadEnvOverridesSecretFile(t *testing.T) {
+	clearEnv(t)
+	secret := writeFile(t, "password", "secret-file-password\n")
+	path := writeFile(t, "config.json", `{
+		"database": {"host": "localhost", "database": "igdb", "password_file": "`+secret+`"},
+		"twitch": {"client_id": "id", "client_secret": "secret"}
+	}`)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Password != "secret-file-password" {
+		t.Errorf("password = %q, want the content of password_file without newline", cfg.Database.Password)
+	}
+
+	t.Setenv("IGDB_DB_PASSWORD", "env-password")
+	cfg, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Password != "env-password" {
+		t.Errorf("password = %q, want IGDB_DB_PASSWORD over password_file", cfg.Database.Password)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("IGDB_STORAGE", "memory")
+	t.Setenv("IGDB_TWITCH_CLIENT_ID", "id")
+	t.Setenv("IGDB_TWITCH_CLIENT_SECRET_FILE", writeFile(t, "secret", "file-secret\n"))
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Twitch.ClientSecret != "file-secret" {
+		t.Errorf("client secret = %q, want the content of IGDB_TWITCH_CLIENT_SECRET_FILE", cfg.Twitch.ClientSecret)
+	}
+
+	t.Setenv("IGDB_TWITCH_CLIENT_SECRET", "env-secret")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("err = %v, want an error for both IGDB_TWITCH_CLIENT_SECRET and its _FILE", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := &Config{Storage: "memory"}
+		cfg.Twitch.ClientID = "id"
+		cfg.Twitch.ClientSecret = "secret"
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("valid config: %v", err)
+	}
+
+	depth := MaxExpandDepth + 1
+	tests := []struct {
+		name  string
+		edit  func(cfg *Config)
+		error string
+	}{
+		{"missing client id", func(cfg *Config) { cfg.Twitch.ClientID = "" }, "twitch.client_id is required"},
+		{"unknown storage", func(cfg *Config) { cfg.Storage = "redis" }, `storage "redis"`},
+		{"missing database", func(cfg *Config) { cfg.Storage = "" }, "database.database is required"},
+		{"uri with host", func(cfg *Config) {
+			cfg.Storage = "mongodb"
+			cfg.Database.Database = "igdb"
+			cfg.Database.URI = "mongodb://localhost"
+			cfg.Database.Host = "localhost"
+		}, "cannot be combined"},
+		{"invalid address", func(cfg *Config) { cfg.Address = "localhost" }, "not host:port"},
+		{"relative external url", func(cfg *Config) { cfg.ExternalUrl = "/webhook" }, "external_url"},
+		{"expand depth", func(cfg *Config) { cfg.Aggregation.ExpandDepth = &depth }, "aggregation.expand_depth"},
+		{"log level", func(cfg *Config) { cfg.Log.Level = "trace" }, "log.level"},
+	}
+	for _, tt := range tests {
+		cfg := valid()
+		tt.edit(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), tt.error) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.error)
+		}
+	}
+}
+
+func TestValidateServer(t *testing.T) {
+	cfg := &Config{Address: "localhost:8080", ExternalUrl: "https://example.com"}
+	if err := cfg.ValidateServer(); err == nil || !strings.Contains(err.Error(), "webhook_secret is required") {
+		t.Errorf("err = %v, want a missing webhook_secret error", err)
+	}
+}
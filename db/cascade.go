@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// IsEmbeddedInGames reports whether items of e are embedded in aggregated
+// games, either directly or as an expanded reference.
+func IsEmbeddedInGames(e endpoint.Name) bool {
+	return len(gameEmbeddedPaths(e, expandDepth())) > 0
+}
+
+// PatchEmbeddedItem replaces every copy of item embedded in aggregated games
+// and returns the number of patched games. The references of item are
+// expanded as deep as they are in aggregated games, items that are not
+// stored are left as ids.
+func (m *MongoDB) PatchEmbeddedItem(ctx context.Context, e endpoint.Name, item IdGetter) (int64, error) {
+	id := item.GetId()
+	return patchEmbeddedItem(ctx, m, e, item, func(p embeddedPath) (int64, error) {
+		filter := bson.M{p.field + ".id": id}
+		var update bson.M
+		opts := options.UpdateMany()
+		switch {
+		case p.list && p.nested == "":
+			update = bson.M{"$set": bson.M{p.field + ".$[item]": item}}
+			opts.SetArrayFilters([]any{bson.M{"item.id": id}})
+		case p.list:
+			filter = bson.M{p.field + "." + p.nested + ".id": id}
+			update = bson.M{"$set": bson.M{p.field + ".$[item]." + p.nested: item}}
+			opts.SetArrayFilters([]any{bson.M{"item." + p.nested + ".id": id}})
+		case p.nested == "":
+			update = bson.M{"$set": bson.M{p.field: item}}
+		default:
+			filter = bson.M{p.field + "." + p.nested + ".id": id}
+			update = bson.M{"$set": bson.M{p.field + "." + p.nested: item}}
+		}
+
+		res, err := m.GameCollection.UpdateMany(ctx, filter, update, opts)
+		if err != nil {
+			return 0, fmt.Errorf("failed to patch %s %d in game_details: %w", string(e), id, err)
+		}
+		return res.ModifiedCount, nil
+	})
+}
+
+// patchEmbeddedItem calls patch for every place in aggregated games holding
+// items of e, after expanding item as deep as it is expanded there.
+func patchEmbeddedItem(ctx context.Context, s Store, e endpoint.Name, item IdGetter, patch func(p embeddedPath) (int64, error)) (int64, error) {
+	depth := expandDepth()
+	paths := gameEmbeddedPaths(e, depth)
+	// the deepest copies are expanded the least, so item is expanded
+	// further before each shallower path
+	slices.SortFunc(paths, func(a, b embeddedPath) int { return b.level - a.level })
+
+	patched := int64(0)
+	frontier := map[endpoint.Name][]any{e: {item}}
+	expanded := 0
+	for _, p := range paths {
+		if want := depth - p.level; want > expanded {
+			var err error
+			frontier, err = expandItems(ctx, s, nil, gameExpansions, frontier, want-expanded)
+			if err != nil {
+				return patched, err
+			}
+			expanded = want
+		}
+
+		n, err := patch(p)
+		if err != nil {
+			return patched, err
+		}
+		patched += n
+	}
+	return patched, nil
+}
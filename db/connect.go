@@ -0,0 +1,131 @@
+package db
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"igdb-database/config"
+	"igdb-database/metrics"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
+)
+
+const (
+	defaultPort = 27017
+	// defaultTimeout limits operations whose context has no deadline.
+	defaultTimeout = time.Minute
+)
+
+// clientOptions builds the connection options from the database config. A
+// uri is applied first and the structured options override it, so secrets
+// like the password can be kept out of the uri.
+func clientOptions(cfg *config.Config) (*options.ClientOptions, error) {
+	d := cfg.Database
+	opts := options.Client().
+		SetConnectTimeout(3 * time.Second).
+		SetTimeout(defaultTimeout).
+		SetBSONOptions(&options.BSONOptions{UseJSONStructTags: true}).
+		SetMonitor(metrics.MongoMonitor())
+
+	if d.URI != "" {
+		opts.ApplyURI(d.URI)
+	} else {
+		hosts := d.Hosts
+		if len(hosts) == 0 {
+			port := d.Port
+			if port == 0 {
+				port = defaultPort
+			}
+			hosts = []string{net.JoinHostPort(d.Host, strconv.Itoa(port))}
+		}
+		opts.SetHosts(hosts)
+	}
+
+	if d.User != "" {
+		opts.SetAuth(options.Credential{
+			Username:   d.User,
+			Password:   d.Password,
+			AuthSource: d.AuthSource,
+		})
+	}
+	if d.ReplicaSet != "" {
+		opts.SetReplicaSet(d.ReplicaSet)
+	}
+	if d.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(d.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create read preference: %w", err)
+		}
+		opts.SetReadPreference(rp)
+	}
+	if d.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(d.MaxPoolSize)
+	}
+	if d.MinPoolSize > 0 {
+		opts.SetMinPoolSize(d.MinPoolSize)
+	}
+	if d.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(time.Duration(d.ConnectTimeout))
+	}
+	if d.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(time.Duration(d.ServerSelectionTimeout))
+	}
+	if d.Timeout > 0 {
+		opts.SetTimeout(time.Duration(d.Timeout))
+	}
+
+	if d.TLS.Enabled || d.TLS.CAFile != "" || d.TLS.CertFile != "" {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid mongodb options: %w", err)
+	}
+	return opts, nil
+}
+
+func newTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	t := cfg.Database.TLS
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", t.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if t.CertFile != "" {
+		keyFile := t.KeyFile
+		if keyFile == "" {
+			keyFile = t.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(t.CertFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"igdb-database/config"
-	"log"
+	"igdb-database/logging"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
@@ -12,6 +14,7 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var (
@@ -20,28 +23,28 @@ var (
 )
 
 type MongoDB struct {
-	client         *mongo.Client
-	Collections    map[endpoint.Name]*mongo.Collection
-	GameCollection *mongo.Collection
+	client                 *mongo.Client
+	Collections            map[endpoint.Name]*mongo.Collection
+	GameCollection         *mongo.Collection
+	SyncStateCollection    *mongo.Collection
+	RunCollection          *mongo.Collection
+	WebhookEventCollection *mongo.Collection
+	DeadLetterCollection   *mongo.Collection
+	ViewCollections        map[string]*mongo.Collection
 }
 
-func GetInstance() *MongoDB {
+func GetInstance(ctx context.Context) *MongoDB {
 	once.Do(func() {
-		bsonOpts := &options.BSONOptions{
-			UseJSONStructTags: true,
+		opts, err := clientOptions(config.C())
+		if err != nil {
+			slog.Error("failed to configure mongodb", logging.Err(err))
+			os.Exit(1)
 		}
 
-		clientOptions := options.Client().ApplyURI(fmt.Sprintf(
-			"mongodb://%s:%s@%s:%v",
-			config.C().Database.User,
-			config.C().Database.Password,
-			config.C().Database.Host,
-			config.C().Database.Port,
-		)).SetConnectTimeout(3 * time.Second).SetBSONOptions(bsonOpts)
-
-		client, err := mongo.Connect(clientOptions)
+		client, err := mongo.Connect(opts)
 		if err != nil {
-			log.Fatalf("failed to connect to mongodb: %v", err)
+			slog.Error("failed to connect to mongodb", logging.Err(err))
+			os.Exit(1)
 		}
 		instance = &MongoDB{
 			client:      client,
@@ -53,14 +56,22 @@ func GetInstance() *MongoDB {
 		}
 
 		instance.GameCollection = client.Database(config.C().Database.Database).Collection("game_details")
-		instance.createIndex()
+		instance.SyncStateCollection = client.Database(config.C().Database.Database).Collection("sync_state")
+		instance.RunCollection = client.Database(config.C().Database.Database).Collection("runs")
+		instance.WebhookEventCollection = client.Database(config.C().Database.Database).Collection("webhook_events")
+		instance.DeadLetterCollection = client.Database(config.C().Database.Database).Collection("webhook_dead_letters")
+		instance.ViewCollections = make(map[string]*mongo.Collection, len(Views))
+		for _, v := range Views {
+			instance.ViewCollections[v.Name()] = client.Database(config.C().Database.Database).Collection(v.Name())
+		}
+		instance.createIndex(ctx)
 	})
 
 	return instance
 }
 
-func (m *MongoDB) createIndex() {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*60*time.Second)
+func (m *MongoDB) createIndex(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Minute)
 	defer cancel()
 
 	textIndexMap := map[endpoint.Name]string{
@@ -75,7 +86,7 @@ func (m *MongoDB) createIndex() {
 			},
 		})
 		if err != nil {
-			log.Printf("failed to create index %s for %s: %v", idx, string(e), err)
+			slog.Warn("failed to create index", "collection", string(e), "index", idx, logging.Err(err))
 		}
 	}
 
@@ -107,7 +118,7 @@ func (m *MongoDB) createIndex() {
 				},
 			})
 			if err != nil {
-				log.Printf("failed to create index %s for %s: %v", idx, string(e), err)
+				slog.Warn("failed to create index", "collection", string(e), "index", idx, logging.Err(err))
 			}
 		}
 	}
@@ -123,7 +134,7 @@ func (m *MongoDB) createIndex() {
 			Options: options.Index().SetUnique(true),
 		})
 		if err != nil {
-			log.Printf("failed to create index id for %s: %v", string(e), err)
+			slog.Warn("failed to create index", "collection", string(e), "index", "id", logging.Err(err))
 		}
 		_, err = m.Collections[e].Indexes().CreateOne(ctx, mongo.IndexModel{
 			Keys: bson.D{
@@ -131,28 +142,162 @@ func (m *MongoDB) createIndex() {
 			},
 		})
 		if err != nil {
-			log.Printf("failed to create index id for %s: %v", string(e), err)
+			slog.Warn("failed to create index", "collection", string(e), "index", "updated_at", logging.Err(err))
 		}
 	}
 
-	_, err := m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+	_, err := m.Collections[endpoint.EPExternalGames].Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "external_game_source.id", Value: 1},
+			{Key: "uid", Value: 1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", string(endpoint.EPExternalGames), "index", "external_game_source.id_uid", logging.Err(err))
+	}
+
+	_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
 		Keys: bson.D{
 			{Key: "id", Value: 1},
 		},
 		Options: options.Index().SetUnique(true),
 	})
 	if err != nil {
-		log.Printf("failed to create index id for game_details: %v", err)
+		slog.Warn("failed to create index", "collection", "game_details", "index", "id", logging.Err(err))
+	}
+	_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "slug", Value: 1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "game_details", "index", "slug", logging.Err(err))
+	}
+	_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "all_names", Value: "text"},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "game_details", "index", "all_names", logging.Err(err))
+	}
+	_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "total_rating_count", Value: -1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "game_details", "index", "total_rating_count", logging.Err(err))
+	}
+	_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "search_keys", Value: 1},
+			{Key: "total_rating_count", Value: -1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "game_details", "index", "search_keys", logging.Err(err))
+	}
+	for _, idx := range []string{"parent_game", "version_parent"} {
+		_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: idx, Value: 1},
+			},
+		})
+		if err != nil {
+			slog.Warn("failed to create index", "collection", "game_details", "index", idx, logging.Err(err))
+		}
+	}
+
+	if config.C().Aggregation.RelatedSummaries {
+		for _, r := range relatedGames {
+			idx := "related." + r.field + ".id"
+			_, err = m.GameCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{
+					{Key: idx, Value: 1},
+				},
+				Options: options.Index().SetSparse(true),
+			})
+			if err != nil {
+				slog.Warn("failed to create index", "collection", "game_details", "index", idx, logging.Err(err))
+			}
+		}
+	}
+
+	for _, v := range Views {
+		_, err = m.ViewCollections[v.Name()].Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		})
+		if err != nil {
+			slog.Warn("failed to create index", "collection", v.Name(), "index", "id", logging.Err(err))
+		}
+		for _, idx := range v.Paths(endpoint.EPGames) {
+			_, err = m.ViewCollections[v.Name()].Indexes().CreateOne(ctx, mongo.IndexModel{
+				Keys: bson.D{
+					{Key: idx, Value: 1},
+				},
+			})
+			if err != nil {
+				slog.Warn("failed to create index", "collection", v.Name(), "index", idx, logging.Err(err))
+			}
+		}
+	}
+
+	_, err = m.SyncStateCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "endpoint", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "sync_state", "index", "endpoint", logging.Err(err))
+	}
+
+	_, err = m.WebhookEventCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "status", Value: 1},
+			{Key: "next_attempt_at", Value: 1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "webhook_events", "index", "status", logging.Err(err))
+	}
+	_, err = m.WebhookEventCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "endpoint", Value: 1},
+			{Key: "entity_id", Value: 1},
+		},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "webhook_events", "index", "entity_id", logging.Err(err))
+	}
+	_, err = m.DeadLetterCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{
+			{Key: "failed_at", Value: -1},
+		},
+	})
+	if err != nil {
+		slog.Warn("failed to create index", "collection", "webhook_dead_letters", "index", "failed_at", logging.Err(err))
 	}
 }
 
-func CountDocuments(e endpoint.Name) (int64, error) {
-	coll := GetInstance().Collections[e]
+// Ping checks that MongoDB is reachable.
+func (m *MongoDB) Ping(ctx context.Context) error {
+	if err := m.client.Ping(ctx, nil); err != nil {
+		return fmt.Errorf("failed to ping mongodb: %w", err)
+	}
+	return nil
+}
+
+func (m *MongoDB) CountDocuments(ctx context.Context, e endpoint.Name) (int64, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return 0, fmt.Errorf("collection not found")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
 	count, err := coll.CountDocuments(ctx, bson.M{})
 	if err != nil {
 		return 0, fmt.Errorf("failed to count  %s: %w", string(e), err)
@@ -160,13 +305,11 @@ func CountDocuments(e endpoint.Name) (int64, error) {
 	return count, nil
 }
 
-func EstimatedDocumentCount(e endpoint.Name) (int64, error) {
-	coll := GetInstance().Collections[e]
+func (m *MongoDB) EstimatedDocumentCount(ctx context.Context, e endpoint.Name) (int64, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return 0, fmt.Errorf("collection not found")
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 	count, err := coll.EstimatedDocumentCount(ctx)
 	if err != nil {
 		return 0, fmt.Errorf("failed to count  %s: %w", string(e), err)
@@ -174,35 +317,18 @@ func EstimatedDocumentCount(e endpoint.Name) (int64, error) {
 	return count, nil
 }
 
-func RemoveByID(e endpoint.Name, ids []bson.ObjectID) error {
-	coll := GetInstance().Collections[e]
-	if coll == nil {
-		return fmt.Errorf("collection not found")
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := coll.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}})
-	if err != nil {
-		return fmt.Errorf("failed to remove games: %w", err)
-	}
-	return nil
-}
-
-func GetItemsByIds[T any](e endpoint.Name, ids []uint64) ([]*T, error) {
-	coll := GetInstance().Collections[e]
+func (m *MongoDB) GetItemsByIds(ctx context.Context, e endpoint.Name, ids []uint64) ([]bson.Raw, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return nil, fmt.Errorf("collection not found")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+time.Duration(len(ids)*200)*time.Millisecond)
-	defer cancel()
 	cursor, err := coll.Find(ctx, bson.M{"id": bson.M{"$in": ids}})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get items: %w", err)
 	}
 
-	var items []*T
+	var items []bson.Raw
 	err = cursor.All(ctx, &items)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get items: %w", err)
@@ -210,74 +336,59 @@ func GetItemsByIds[T any](e endpoint.Name, ids []uint64) ([]*T, error) {
 	return items, nil
 }
 
-func GetItemById[T any](e endpoint.Name, id uint64) (*T, error) {
-	coll := GetInstance().Collections[e]
+func (m *MongoDB) GetItemById(ctx context.Context, e endpoint.Name, id uint64) (bson.Raw, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return nil, fmt.Errorf("collection not found")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	var item *T
-	err := coll.FindOne(ctx, bson.M{"id": id}).Decode(&item)
+	item, err := coll.FindOne(ctx, bson.M{"id": id}).Raw()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get item: %w", err)
 	}
 	return item, nil
 }
 
-func SaveItem[T any](e endpoint.Name, item *T) error {
-	type IdGetter interface {
-		GetId() uint64
-	}
-	id := any(item).(IdGetter).GetId()
-	filter := bson.M{"id": id}
+func (m *MongoDB) SaveItem(ctx context.Context, e endpoint.Name, item IdGetter) error {
+	filter := bson.M{"id": item.GetId()}
 	update := bson.M{"$set": item}
 	opts := options.UpdateOne().SetUpsert(true)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_, err := GetInstance().Collections[e].UpdateOne(ctx, filter, update, opts)
+	_, err := m.Collections[e].UpdateOne(ctx, filter, update, opts)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func SaveItems[T any](e endpoint.Name, items []*T) error {
-	type IdGetter interface {
-		GetId() uint64
-	}
-
+func (m *MongoDB) SaveItems(ctx context.Context, e endpoint.Name, items []IdGetter) error {
 	updateModel := make([]mongo.WriteModel, 0, len(items))
 	for _, item := range items {
-		updateModel = append(updateModel, mongo.NewUpdateOneModel().SetFilter(bson.M{"id": any(item).(IdGetter).GetId()}).SetUpdate(bson.M{"$set": item}).SetUpsert(true))
+		updateModel = append(updateModel, mongo.NewUpdateOneModel().SetFilter(bson.M{"id": item.GetId()}).SetUpdate(bson.M{"$set": item}).SetUpsert(true))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(len(items))*200*time.Millisecond)
-	defer cancel()
-	_, err := GetInstance().Collections[e].BulkWrite(ctx, updateModel)
+	_, err := m.Collections[e].BulkWrite(ctx, updateModel)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func GetItemsPaginated[T any](e endpoint.Name, skip int64, limit int64) ([]*T, error) {
-	coll := GetInstance().Collections[e]
+// GetItemsAfter returns up to limit items of e with an id greater than
+// afterId, sorted by id.
+func (m *MongoDB) GetItemsAfter(ctx context.Context, e endpoint.Name, afterId uint64, limit int64) ([]bson.Raw, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return nil, fmt.Errorf("collection not found")
 	}
 
-	opts := options.Find().SetSort(bson.M{"id": 1}).SetSkip(skip).SetLimit(limit)
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(limit)*time.Millisecond*200)
-	defer cancel()
-	cursor, err := coll.Find(ctx, bson.M{}, opts)
+	opts := options.Find().SetSort(bson.M{"id": 1}).SetLimit(limit)
+	cursor, err := coll.Find(ctx, bson.M{"id": bson.M{"$gt": afterId}}, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get items %s: %w", string(e), err)
 	}
 
-	var items []*T
+	var items []bson.Raw
 	err = cursor.All(ctx, &items)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get items %s: %w", string(e), err)
@@ -285,24 +396,22 @@ func GetItemsPaginated[T any](e endpoint.Name, skip int64, limit int64) ([]*T, e
 	return items, nil
 }
 
-func GetItemsSorted[T any](e endpoint.Name, limit int, sort bson.M) ([]*T, error) {
-	coll := GetInstance().Collections[e]
+// GetLatestUpdatedAt returns the newest updated_at stored in the collection of e.
+// It returns ErrNotFound if the collection is empty and a nil timestamp if
+// the stored items have no updated_at.
+func (m *MongoDB) GetLatestUpdatedAt(ctx context.Context, e endpoint.Name) (*timestamppb.Timestamp, error) {
+	coll := m.Collections[e]
 	if coll == nil {
 		return nil, fmt.Errorf("collection not found")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second+time.Duration(limit*200)*time.Millisecond)
-	defer cancel()
-	opts := options.Find().SetLimit(int64(limit)).SetSort(sort)
-	cursor, err := coll.Find(ctx, bson.M{}, opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get items: %w", err)
+	opts := options.FindOne().SetSort(bson.M{"updated_at": -1}).SetProjection(bson.M{"updated_at": 1})
+	var item struct {
+		UpdatedAt *timestamppb.Timestamp `json:"updated_at"`
 	}
-
-	var items []*T
-	err = cursor.All(ctx, &items)
+	err := coll.FindOne(ctx, bson.M{}, opts).Decode(&item)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get items: %w", err)
+		return nil, fmt.Errorf("failed to get latest updated_at %s: %w", string(e), err)
 	}
-	return items, nil
+	return item.UpdatedAt, nil
 }
@@ -0,0 +1,167 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// embeddedListFields maps endpoints to the list fields of both pb.Game and
+// model.Game that embed items of that endpoint.
+var embeddedListFields = map[endpoint.Name]string{
+	endpoint.EPAgeRatings:         "age_ratings",
+	endpoint.EPAlternativeNames:   "alternative_names",
+	endpoint.EPArtworks:           "artworks",
+	endpoint.EPCollections:        "collections",
+	endpoint.EPExternalGames:      "external_games",
+	endpoint.EPFranchises:         "franchises",
+	endpoint.EPGameEngines:        "game_engines",
+	endpoint.EPGameLocalizations:  "game_localizations",
+	endpoint.EPGameModes:          "game_modes",
+	endpoint.EPGameVideos:         "videos",
+	endpoint.EPGenres:             "genres",
+	endpoint.EPInvolvedCompanies:  "involved_companies",
+	endpoint.EPKeywords:           "keywords",
+	endpoint.EPLanguageSupports:   "language_supports",
+	endpoint.EPMultiplayerModes:   "multiplayer_modes",
+	endpoint.EPPlatforms:          "platforms",
+	endpoint.EPPlayerPerspectives: "player_perspectives",
+	endpoint.EPReleaseDates:       "release_dates",
+	endpoint.EPScreenshots:        "screenshots",
+	endpoint.EPThemes:             "themes",
+	endpoint.EPWebsites:           "websites",
+}
+
+// embeddedSingleFields maps endpoints to the single value fields of both
+// pb.Game and model.Game that embed an item of that endpoint.
+var embeddedSingleFields = map[endpoint.Name]string{
+	endpoint.EPCovers:       "cover",
+	endpoint.EPFranchises:   "franchise",
+	endpoint.EPGameStatuses: "game_status",
+	endpoint.EPGameTypes:    "game_type",
+}
+
+var relatedGameListFields = []string{
+	"bundles",
+	"dlcs",
+	"expanded_games",
+	"expansions",
+	"forks",
+	"ports",
+	"remakes",
+	"remasters",
+	"similar_games",
+	"standalone_expansions",
+}
+
+var relatedGameSingleFields = []string{
+	"parent_game",
+	"version_parent",
+}
+
+// RemoveItem deletes the item with the given IGDB id from the collection of e.
+func (m *MongoDB) RemoveItem(ctx context.Context, e endpoint.Name, id uint64) error {
+	coll := m.Collections[e]
+	if coll == nil {
+		return fmt.Errorf("collection not found")
+	}
+
+	_, err := coll.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s %d: %w", string(e), id, err)
+	}
+	return nil
+}
+
+// RemoveFromGames strips the deleted item of e from every game and
+// aggregated game that references it, including the copies expanded inside
+// other embedded items, e.g. a company logo inside involved companies.
+func (m *MongoDB) RemoveFromGames(ctx context.Context, e endpoint.Name, id uint64) error {
+	if e == endpoint.EPGames {
+		return m.removeRelatedGame(ctx, id)
+	}
+
+	games := m.Collections[endpoint.EPGames]
+	details := m.GameCollection
+
+	for _, p := range gameEmbeddedPaths(e, expandDepth()) {
+		filter := bson.M{p.field + ".id": id}
+		var update any
+		opts := options.UpdateMany()
+		switch {
+		case p.list && p.nested == "":
+			update = bson.M{"$pull": bson.M{p.field: bson.M{"id": id}}}
+		case p.list:
+			filter = bson.M{p.field + "." + p.nested + ".id": id}
+			update = bson.M{"$unset": bson.M{p.field + ".$[item]." + p.nested: ""}}
+			opts.SetArrayFilters([]any{bson.M{"item." + p.nested + ".id": id}})
+		case p.nested == "":
+			update = bson.M{"$unset": bson.M{p.field: ""}}
+		default:
+			filter = bson.M{p.field + "." + p.nested + ".id": id}
+			update = bson.M{"$unset": bson.M{p.field + "." + p.nested: ""}}
+		}
+
+		// games hold the references unexpanded
+		if p.nested == "" {
+			if _, err := games.UpdateMany(ctx, filter, update); err != nil {
+				return fmt.Errorf("failed to remove %s %d from games: %w", string(e), id, err)
+			}
+		}
+		if e == endpoint.EPAlternativeNames && p.list && p.nested == "" {
+			// all_names is derived from the alternative names, rebuild it
+			// after the removal
+			update = mongo.Pipeline{
+				{{Key: "$set", Value: bson.M{p.field: bson.M{"$filter": bson.M{
+					"input": "$" + p.field,
+					"cond":  bson.M{"$ne": bson.A{"$$this.id", id}},
+				}}}}},
+				{{Key: "$set", Value: bson.M{"all_names": bson.M{"$concatArrays": bson.A{
+					bson.A{"$name"},
+					bson.M{"$ifNull": bson.A{"$" + p.field + ".name", bson.A{}}},
+				}}}}},
+			}
+		}
+		if _, err := details.UpdateMany(ctx, filter, update, opts); err != nil {
+			return fmt.Errorf("failed to remove %s %d from game_details: %w", string(e), id, err)
+		}
+	}
+	return nil
+}
+
+// removeRelatedGame deletes the aggregated game and strips its id from the
+// related game lists of other games.
+func (m *MongoDB) removeRelatedGame(ctx context.Context, id uint64) error {
+	games := m.Collections[endpoint.EPGames]
+	details := m.GameCollection
+
+	if _, err := details.DeleteOne(ctx, bson.M{"id": id}); err != nil {
+		return fmt.Errorf("failed to remove game %d from game_details: %w", id, err)
+	}
+
+	for _, field := range relatedGameListFields {
+		_, err := games.UpdateMany(ctx, bson.M{field + ".id": id}, bson.M{"$pull": bson.M{field: bson.M{"id": id}}})
+		if err != nil {
+			return fmt.Errorf("failed to remove game %d from games %s: %w", id, field, err)
+		}
+		_, err = details.UpdateMany(ctx, bson.M{field: id}, bson.M{"$pull": bson.M{field: id, "related." + field: bson.M{"id": id}}})
+		if err != nil {
+			return fmt.Errorf("failed to remove game %d from game_details %s: %w", id, field, err)
+		}
+	}
+	for _, field := range relatedGameSingleFields {
+		_, err := games.UpdateMany(ctx, bson.M{field + ".id": id}, bson.M{"$unset": bson.M{field: ""}})
+		if err != nil {
+			return fmt.Errorf("failed to remove game %d from games %s: %w", id, field, err)
+		}
+		_, err = details.UpdateMany(ctx, bson.M{field: id}, bson.M{"$unset": bson.M{field: "", "related." + field: ""}})
+		if err != nil {
+			return fmt.Errorf("failed to remove game %d from game_details %s: %w", id, field, err)
+		}
+	}
+	return nil
+}
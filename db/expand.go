@@ -0,0 +1,318 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/config"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+)
+
+// expansion replaces a reference inside an item embedded in aggregated games,
+// which IGDB only fills with the id, by the referenced item.
+type expansion interface {
+	parentName() endpoint.Name
+	endpointName() endpoint.Name
+	// field is the json name of the reference in the parent.
+	field() string
+	// expand sets the references of parents and returns the referenced
+	// items, so their references can be expanded in turn.
+	expand(ctx context.Context, s Store, client *igdb.Client, parents []any) ([]any, error)
+}
+
+// gameExpansions declares the references expanded in aggregated games. An
+// expansion applies on every level its parent is found on, e.g. the logo of
+// a platform is expanded in platforms and in the platform of release dates.
+var gameExpansions = []expansion{
+	refExpansion(endpoint.EPAgeRatings, "organization", endpoint.EPAgeRatingOrganizations, (*pb.AgeRating).GetOrganization, func(c *igdb.Client) endpoint.EntityEndpoint[pb.AgeRatingOrganization] {
+		return c.AgeRatingOrganizations
+	}, func(p *pb.AgeRating, v *pb.AgeRatingOrganization) { p.Organization = v }),
+	refExpansion(endpoint.EPAgeRatings, "rating_category", endpoint.EPAgeRatingCategories, (*pb.AgeRating).GetRatingCategory, func(c *igdb.Client) endpoint.EntityEndpoint[pb.AgeRatingCategory] { return c.AgeRatingCategories }, func(p *pb.AgeRating, v *pb.AgeRatingCategory) { p.RatingCategory = v }),
+	refExpansion(endpoint.EPCollections, "type", endpoint.EPCollectionTypes, (*pb.Collection).GetType, func(c *igdb.Client) endpoint.EntityEndpoint[pb.CollectionType] { return c.CollectionTypes }, func(p *pb.Collection, v *pb.CollectionType) { p.Type = v }),
+	refExpansion(endpoint.EPCompanies, "logo", endpoint.EPCompanyLogos, (*pb.Company).GetLogo, func(c *igdb.Client) endpoint.EntityEndpoint[pb.CompanyLogo] { return c.CompanyLogos }, func(p *pb.Company, v *pb.CompanyLogo) { p.Logo = v }),
+	refExpansion(endpoint.EPExternalGames, "external_game_source", endpoint.EPExternalGameSources, (*pb.ExternalGame).GetExternalGameSource, func(c *igdb.Client) endpoint.EntityEndpoint[pb.ExternalGameSource] { return c.ExternalGameSources }, func(p *pb.ExternalGame, v *pb.ExternalGameSource) { p.ExternalGameSource = v }),
+	refExpansion(endpoint.EPGameLocalizations, "region", endpoint.EPRegions, (*pb.GameLocalization).GetRegion, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Region] { return c.Regions }, func(p *pb.GameLocalization, v *pb.Region) { p.Region = v }),
+	refExpansion(endpoint.EPInvolvedCompanies, "company", endpoint.EPCompanies, (*pb.InvolvedCompany).GetCompany, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Company] { return c.Companies }, func(p *pb.InvolvedCompany, v *pb.Company) { p.Company = v }),
+	refExpansion(endpoint.EPLanguageSupports, "language", endpoint.EPLanguages, (*pb.LanguageSupport).GetLanguage, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Language] { return c.Languages }, func(p *pb.LanguageSupport, v *pb.Language) { p.Language = v }),
+	refExpansion(endpoint.EPLanguageSupports, "language_support_type", endpoint.EPLanguageSupportTypes, (*pb.LanguageSupport).GetLanguageSupportType, func(c *igdb.Client) endpoint.EntityEndpoint[pb.LanguageSupportType] { return c.LanguageSupportTypes }, func(p *pb.LanguageSupport, v *pb.LanguageSupportType) { p.LanguageSupportType = v }),
+	refExpansion(endpoint.EPMultiplayerModes, "platform", endpoint.EPPlatforms, (*pb.MultiplayerMode).GetPlatform, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Platform] { return c.Platforms }, func(p *pb.MultiplayerMode, v *pb.Platform) { p.Platform = v }),
+	refExpansion(endpoint.EPPlatforms, "platform_family", endpoint.EPPlatformFamilies, (*pb.Platform).GetPlatformFamily, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlatformFamily] { return c.PlatformFamilies }, func(p *pb.Platform, v *pb.PlatformFamily) { p.PlatformFamily = v }),
+	refExpansion(endpoint.EPPlatforms, "platform_logo", endpoint.EPPlatformLogos, (*pb.Platform).GetPlatformLogo, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlatformLogo] { return c.PlatformLogos }, func(p *pb.Platform, v *pb.PlatformLogo) { p.PlatformLogo = v }),
+	refExpansion(endpoint.EPPlatforms, "platform_type", endpoint.EPPlatformTypes, (*pb.Platform).GetPlatformType, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlatformType] { return c.PlatformTypes }, func(p *pb.Platform, v *pb.PlatformType) { p.PlatformType = v }),
+	refExpansion(endpoint.EPReleaseDates, "platform", endpoint.EPPlatforms, (*pb.ReleaseDate).GetPlatform, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Platform] { return c.Platforms }, func(p *pb.ReleaseDate, v *pb.Platform) { p.Platform = v }),
+	refExpansion(endpoint.EPReleaseDates, "release_region", endpoint.EPReleaseDateRegions, (*pb.ReleaseDate).GetReleaseRegion, func(c *igdb.Client) endpoint.EntityEndpoint[pb.ReleaseDateRegion] { return c.ReleaseDateRegions }, func(p *pb.ReleaseDate, v *pb.ReleaseDateRegion) { p.ReleaseRegion = v }),
+	refExpansion(endpoint.EPReleaseDates, "status", endpoint.EPReleaseDateStatuses, (*pb.ReleaseDate).GetStatus, func(c *igdb.Client) endpoint.EntityEndpoint[pb.ReleaseDateStatus] { return c.ReleaseDateStatuses }, func(p *pb.ReleaseDate, v *pb.ReleaseDateStatus) { p.Status = v }),
+	refExpansion(endpoint.EPWebsites, "type", endpoint.EPWebsiteTypes, (*pb.Website).GetType, func(c *igdb.Client) endpoint.EntityEndpoint[pb.WebsiteType] { return c.WebsiteTypes }, func(p *pb.Website, v *pb.WebsiteType) { p.Type = v }),
+}
+
+// itemExpansion expands the reference to a C in items of type P.
+type itemExpansion[P any, C any] struct {
+	parent    endpoint.Name
+	name      endpoint.Name
+	fieldName string
+	get       func(parent *P) *C
+	fetch     func(client *igdb.Client) endpoint.EntityEndpoint[C]
+	set       func(parent *P, item *C)
+}
+
+func refExpansion[P any, C any](
+	parent endpoint.Name,
+	field string,
+	name endpoint.Name,
+	get func(parent *P) *C,
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[C],
+	set func(parent *P, item *C),
+) expansion {
+	return &itemExpansion[P, C]{
+		parent:    parent,
+		name:      name,
+		fieldName: field,
+		get:       get,
+		fetch:     fetch,
+		set:       set,
+	}
+}
+
+func (x *itemExpansion[P, C]) parentName() endpoint.Name {
+	return x.parent
+}
+
+func (x *itemExpansion[P, C]) endpointName() endpoint.Name {
+	return x.name
+}
+
+func (x *itemExpansion[P, C]) field() string {
+	return x.fieldName
+}
+
+func (x *itemExpansion[P, C]) expand(ctx context.Context, s Store, client *igdb.Client, parents []any) ([]any, error) {
+	typed := make([]*P, 0, len(parents))
+	ids := make([]uint64, 0, len(parents))
+	for _, p := range parents {
+		parent, ok := p.(*P)
+		if !ok || parent == nil {
+			continue
+		}
+		typed = append(typed, parent)
+		if ref := x.get(parent); ref != nil {
+			ids = append(ids, any(ref).(IdGetter).GetId())
+		}
+	}
+
+	var fetcher endpoint.EntityEndpoint[C]
+	if client != nil {
+		fetcher = x.fetch(client)
+	}
+	found, err := resolveItems(ctx, s, x.name, fetcher, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, parent := range typed {
+		ref := x.get(parent)
+		if ref == nil {
+			continue
+		}
+		if item, ok := found[any(ref).(IdGetter).GetId()]; ok {
+			x.set(parent, item)
+		}
+	}
+	children := make([]any, 0, len(found))
+	for _, item := range found {
+		children = append(children, item)
+	}
+	return children, nil
+}
+
+// itemsExpansion expands the references to a list of C in items of type P.
+type itemsExpansion[P any, C any] struct {
+	parent    endpoint.Name
+	name      endpoint.Name
+	fieldName string
+	get       func(parent *P) []*C
+	fetch     func(client *igdb.Client) endpoint.EntityEndpoint[C]
+	set       func(parent *P, items []*C)
+}
+
+func refsExpansion[P any, C any](
+	parent endpoint.Name,
+	field string,
+	name endpoint.Name,
+	get func(parent *P) []*C,
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[C],
+	set func(parent *P, items []*C),
+) expansion {
+	return &itemsExpansion[P, C]{
+		parent:    parent,
+		name:      name,
+		fieldName: field,
+		get:       get,
+		fetch:     fetch,
+		set:       set,
+	}
+}
+
+func (x *itemsExpansion[P, C]) parentName() endpoint.Name {
+	return x.parent
+}
+
+func (x *itemsExpansion[P, C]) endpointName() endpoint.Name {
+	return x.name
+}
+
+func (x *itemsExpansion[P, C]) field() string {
+	return x.fieldName
+}
+
+// expand keeps the order of the references, references that cannot be
+// resolved are kept as ids.
+func (x *itemsExpansion[P, C]) expand(ctx context.Context, s Store, client *igdb.Client, parents []any) ([]any, error) {
+	typed := make([]*P, 0, len(parents))
+	ids := []uint64{}
+	for _, p := range parents {
+		parent, ok := p.(*P)
+		if !ok || parent == nil {
+			continue
+		}
+		typed = append(typed, parent)
+		for _, ref := range x.get(parent) {
+			ids = append(ids, any(ref).(IdGetter).GetId())
+		}
+	}
+
+	var fetcher endpoint.EntityEndpoint[C]
+	if client != nil {
+		fetcher = x.fetch(client)
+	}
+	found, err := resolveItems(ctx, s, x.name, fetcher, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, parent := range typed {
+		refs := x.get(parent)
+		if len(refs) == 0 {
+			continue
+		}
+		items := make([]*C, 0, len(refs))
+		for _, ref := range refs {
+			if item, ok := found[any(ref).(IdGetter).GetId()]; ok {
+				items = append(items, item)
+			} else {
+				items = append(items, ref)
+			}
+		}
+		x.set(parent, items)
+	}
+	children := make([]any, 0, len(found))
+	for _, item := range found {
+		children = append(children, item)
+	}
+	return children, nil
+}
+
+// expandItems expands the references of items, grouped by endpoint, with
+// expansions depth levels deep and returns the items of the last level.
+// Missing items are fetched from IGDB unless client is nil.
+func expandItems(ctx context.Context, s Store, client *igdb.Client, expansions []expansion, items map[endpoint.Name][]any, depth int) (map[endpoint.Name][]any, error) {
+	for range depth {
+		next := make(map[endpoint.Name][]any)
+		for _, x := range expansions {
+			parents := items[x.parentName()]
+			if len(parents) == 0 {
+				continue
+			}
+			children, err := x.expand(ctx, s, client, parents)
+			if err != nil {
+				return nil, fmt.Errorf("failed to expand %s of %s: %w", x.field(), string(x.parentName()), err)
+			}
+			next[x.endpointName()] = append(next[x.endpointName()], children...)
+		}
+		items = next
+		if len(items) == 0 {
+			break
+		}
+	}
+	return items, nil
+}
+
+// nestedPath is the path of an expanded item inside the item it was
+// expanded in, e.g. platform.platform_logo in a release date.
+type nestedPath struct {
+	path string
+	// level is the number of expansions leading to the item.
+	level int
+}
+
+// nestedPaths returns the paths that hold items of e inside an item of root
+// when expansions are applied depth levels deep. The root itself has an
+// empty path.
+func nestedPaths(root endpoint.Name, e endpoint.Name, expansions []expansion, depth int) []nestedPath {
+	type node struct {
+		name endpoint.Name
+		path string
+	}
+	level := []node{{name: root}}
+	paths := []nestedPath{}
+	for i := 0; len(level) > 0; i++ {
+		for _, n := range level {
+			if n.name == e {
+				paths = append(paths, nestedPath{path: n.path, level: i})
+			}
+		}
+		if i == depth {
+			break
+		}
+		next := []node{}
+		for _, n := range level {
+			for _, x := range expansions {
+				if x.parentName() != n.name {
+					continue
+				}
+				path := x.field()
+				if n.path != "" {
+					path = n.path + "." + path
+				}
+				next = append(next, node{name: x.endpointName(), path: path})
+			}
+		}
+		level = next
+	}
+	return paths
+}
+
+// embeddedPath is a place in aggregated games that holds items of an
+// endpoint.
+type embeddedPath struct {
+	// field is the field of the game, list tells whether it is a list.
+	field string
+	list  bool
+	// nested is the path of the item inside the value of field, empty if
+	// the value is the item itself.
+	nested string
+	level  int
+}
+
+// gameEmbeddedPaths returns the places in aggregated games that hold items
+// of e when references are expanded depth levels deep.
+func gameEmbeddedPaths(e endpoint.Name, depth int) []embeddedPath {
+	paths := []embeddedPath{}
+	for name, field := range embeddedListFields {
+		for _, p := range nestedPaths(name, e, gameExpansions, depth) {
+			paths = append(paths, embeddedPath{field: field, list: true, nested: p.path, level: p.level})
+		}
+	}
+	for name, field := range embeddedSingleFields {
+		for _, p := range nestedPaths(name, e, gameExpansions, depth) {
+			paths = append(paths, embeddedPath{field: field, nested: p.path, level: p.level})
+		}
+	}
+	return paths
+}
+
+// expandDepth is the configured depth of expansions in aggregated games.
+func expandDepth() int {
+	return config.C().ExpandDepth()
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/model"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// ErrAmbiguousSource is returned by FindExternalGameSource when a name
+// matches more than one source.
+var ErrAmbiguousSource = errors.New("ambiguous external game source")
+
+// FindExternalGameSource returns the stored external game source with the
+// given id or name. Names are compared ignoring case, spaces and
+// punctuation, and a unique prefix is enough, so "steam", "gog" and "epic"
+// all work.
+func FindExternalGameSource(ctx context.Context, s Store, source string) (*pb.ExternalGameSource, error) {
+	if id, err := strconv.ParseUint(source, 10, 64); err == nil {
+		return GetItemById[pb.ExternalGameSource](ctx, s, endpoint.EPExternalGameSources, id)
+	}
+
+	name := normalizeSourceName(source)
+	if name == "" {
+		return nil, fmt.Errorf("failed to get external game source %q: %w", source, ErrNotFound)
+	}
+	var prefixed []*pb.ExternalGameSource
+	for sources, err := range IterateItems[pb.ExternalGameSource](ctx, s, endpoint.EPExternalGameSources, 500) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to get external game sources: %w", err)
+		}
+		for _, src := range sources {
+			n := normalizeSourceName(src.Name)
+			if n == name {
+				return src, nil
+			}
+			if strings.HasPrefix(n, name) {
+				prefixed = append(prefixed, src)
+			}
+		}
+	}
+	switch len(prefixed) {
+	case 0:
+		return nil, fmt.Errorf("failed to get external game source %q: %w", source, ErrNotFound)
+	case 1:
+		return prefixed[0], nil
+	default:
+		names := make([]string, 0, len(prefixed))
+		for _, src := range prefixed {
+			names = append(names, src.Name)
+		}
+		return nil, fmt.Errorf("%w %q, matches %s", ErrAmbiguousSource, source, strings.Join(names, ", "))
+	}
+}
+
+func normalizeSourceName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// GetGamesByExternalIds maps the uids of source, e.g. Steam app ids, to
+// their games. Matches are returned in the order of uids, uids without an
+// external game are left out. When a uid belongs to several external games
+// the one with the lowest id whose game is aggregated wins.
+func GetGamesByExternalIds(ctx context.Context, s Store, source *pb.ExternalGameSource, uids []string) ([]*model.ExternalGameMatch, error) {
+	raws, err := s.GetExternalGames(ctx, source.Id, uids)
+	if err != nil {
+		return nil, err
+	}
+	externalGames, err := decodeDocuments[pb.ExternalGame](endpoint.EPExternalGames, raws)
+	if err != nil {
+		return nil, err
+	}
+
+	gameIds := make([]uint64, 0, len(externalGames))
+	for _, eg := range externalGames {
+		if eg.Game != nil {
+			gameIds = append(gameIds, eg.Game.Id)
+		}
+	}
+	games, err := s.GetGamesByIds(ctx, uniqueIds(gameIds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get games of external games: %w", err)
+	}
+	gameMap := make(map[uint64]*model.Game, len(games))
+	for _, game := range games {
+		gameMap[game.Id] = game
+	}
+
+	matches := make(map[string]*model.ExternalGameMatch, len(externalGames))
+	for _, eg := range externalGames {
+		if eg.Game == nil {
+			continue
+		}
+		match := &model.ExternalGameMatch{
+			Uid:            eg.Uid,
+			Source:         source.Name,
+			ExternalGameId: eg.Id,
+			GameId:         eg.Game.Id,
+			Url:            eg.Url,
+			Game:           gameMap[eg.Game.Id],
+		}
+		if prev, ok := matches[eg.Uid]; ok {
+			aggregated, prevAggregated := match.Game != nil, prev.Game != nil
+			if prevAggregated && !aggregated ||
+				aggregated == prevAggregated && match.ExternalGameId > prev.ExternalGameId {
+				continue
+			}
+		}
+		matches[eg.Uid] = match
+	}
+
+	res := make([]*model.ExternalGameMatch, 0, len(matches))
+	for _, uid := range uids {
+		if match, ok := matches[uid]; ok {
+			res = append(res, match)
+		}
+	}
+	return res, nil
+}
+
+// GetExternalGames returns the external games of the source with the given
+// id whose uid is one of uids.
+func (m *MongoDB) GetExternalGames(ctx context.Context, sourceId uint64, uids []string) ([]bson.Raw, error) {
+	filter := bson.M{
+		"external_game_source.id": sourceId,
+		"uid":                     bson.M{"$in": uids},
+	}
+	cursor, err := m.Collections[endpoint.EPExternalGames].Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external games: %w", err)
+	}
+
+	var items []bson.Raw
+	err = cursor.All(ctx, &items)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get external games: %w", err)
+	}
+	return items, nil
+}
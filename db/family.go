@@ -0,0 +1,177 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/model"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	// MaxFamilyDepth is the maximum number of levels walked up to the base
+	// game and down from it.
+	MaxFamilyDepth = 8
+	// maxFamilyGames is the maximum number of games in a family tree.
+	maxFamilyGames = 1000
+)
+
+// familyRelations declares the relations of a game that become branches of
+// its node in the family tree, in the order they are attached. Games whose
+// version parent is the game are attached as versions before them.
+var familyRelations = []struct {
+	get func(game *model.Game) model.GameIds
+	add func(node *model.GameFamilyNode, child *model.GameFamilyNode)
+}{
+	{func(g *model.Game) model.GameIds { return g.Dlcs }, func(n, c *model.GameFamilyNode) { n.Dlcs = append(n.Dlcs, c) }},
+	{func(g *model.Game) model.GameIds { return g.Expansions }, func(n, c *model.GameFamilyNode) { n.Expansions = append(n.Expansions, c) }},
+	{func(g *model.Game) model.GameIds { return g.StandaloneExpansions }, func(n, c *model.GameFamilyNode) { n.StandaloneExpansions = append(n.StandaloneExpansions, c) }},
+	{func(g *model.Game) model.GameIds { return g.Remakes }, func(n, c *model.GameFamilyNode) { n.Remakes = append(n.Remakes, c) }},
+	{func(g *model.Game) model.GameIds { return g.Remasters }, func(n, c *model.GameFamilyNode) { n.Remasters = append(n.Remasters, c) }},
+	{func(g *model.Game) model.GameIds { return g.Ports }, func(n, c *model.GameFamilyNode) { n.Ports = append(n.Ports, c) }},
+}
+
+// familyProjection selects the fields of aggregated games needed to build a
+// family tree.
+var familyProjection = bson.M{
+	"id":                    1,
+	"name":                  1,
+	"slug":                  1,
+	"cover":                 1,
+	"first_release_date":    1,
+	"game_type":             1,
+	"parent_game":           1,
+	"version_parent":        1,
+	"dlcs":                  1,
+	"expansions":            1,
+	"standalone_expansions": 1,
+	"remakes":               1,
+	"remasters":             1,
+	"ports":                 1,
+}
+
+// GetGameFamily returns the family of the aggregated game with the given
+// id. The base game is found by following version parents and parent games,
+// then the tree is built from it up to depth levels deep. Every game is
+// part of the tree once, so cycles in the relations end the branch.
+func GetGameFamily(ctx context.Context, s Store, id uint64, depth int) (*model.GameFamily, error) {
+	depth = min(max(depth, 1), MaxFamilyDepth)
+
+	game, err := s.GetGameById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[uint64]bool{game.Id: true}
+	for range MaxFamilyDepth {
+		parentId := uint64(game.VersionParent)
+		if parentId == 0 {
+			parentId = uint64(game.ParentGame)
+		}
+		if parentId == 0 || seen[parentId] {
+			break
+		}
+		parent, err := s.GetGameById(ctx, parentId)
+		if errors.Is(err, ErrNotFound) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		seen[parentId] = true
+		game = parent
+	}
+
+	type member struct {
+		game *model.Game
+		node *model.GameFamilyNode
+	}
+	root := &model.GameFamilyNode{GameSummary: model.NewGameSummary(game)}
+	res := &model.GameFamily{Id: id, Root: root}
+	seen = map[uint64]bool{game.Id: true}
+	level := []member{{game: game, node: root}}
+	for d := 0; len(level) > 0; d++ {
+		listed := []uint64{}
+		parentIds := make([]uint64, 0, len(level))
+		for _, m := range level {
+			parentIds = append(parentIds, m.game.Id)
+			for _, r := range familyRelations {
+				listed = append(listed, r.get(m.game)...)
+			}
+		}
+		games, err := s.GetFamilyGames(ctx, uniqueIds(listed), parentIds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get family of game %d: %w", id, err)
+		}
+		if d == depth {
+			// the tree is only cut if the last level has family members
+			// that are not in it yet
+			res.Truncated = slices.ContainsFunc(games, func(g *model.Game) bool { return !seen[g.Id] })
+			break
+		}
+		slices.SortFunc(games, func(a, b *model.Game) int { return cmp.Compare(a.Id, b.Id) })
+		gameMap := make(map[uint64]*model.Game, len(games))
+		for _, g := range games {
+			gameMap[g.Id] = g
+		}
+
+		next := []member{}
+		attach := func(parent *model.GameFamilyNode, g *model.Game, add func(node *model.GameFamilyNode, child *model.GameFamilyNode)) {
+			if g == nil || seen[g.Id] {
+				return
+			}
+			if len(seen) >= maxFamilyGames {
+				res.Truncated = true
+				return
+			}
+			seen[g.Id] = true
+			node := &model.GameFamilyNode{GameSummary: model.NewGameSummary(g)}
+			add(parent, node)
+			next = append(next, member{game: g, node: node})
+		}
+		for _, m := range level {
+			for _, g := range games {
+				if uint64(g.VersionParent) == m.game.Id {
+					attach(m.node, g, func(n, c *model.GameFamilyNode) { n.Versions = append(n.Versions, c) })
+				}
+			}
+			for _, r := range familyRelations {
+				for _, childId := range r.get(m.game) {
+					attach(m.node, gameMap[childId], r.add)
+				}
+			}
+			for _, g := range games {
+				if uint64(g.ParentGame) == m.game.Id {
+					attach(m.node, g, func(n, c *model.GameFamilyNode) { n.Children = append(n.Children, c) })
+				}
+			}
+		}
+		level = next
+	}
+	return res, nil
+}
+
+// GetFamilyGames returns the aggregated games with the given ids and the
+// ones whose parent game or version parent is one of parentIds, with the
+// fields needed for family trees only.
+func (m *MongoDB) GetFamilyGames(ctx context.Context, ids []uint64, parentIds []uint64) ([]*model.Game, error) {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"id": bson.M{"$in": ids}},
+		bson.M{"parent_game": bson.M{"$in": parentIds}},
+		bson.M{"version_parent": bson.M{"$in": parentIds}},
+	}}
+	cursor, err := m.GameCollection.Find(ctx, filter, options.Find().SetProjection(familyProjection))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get games: %w", err)
+	}
+
+	var games []*model.Game
+	err = cursor.All(ctx, &games)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get games: %w", err)
+	}
+	return games, nil
+}
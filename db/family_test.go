@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"igdb-database/model"
+	"testing"
+)
+
+func TestGetGameFamilyTruncated(t *testing.T) {
+	loadTestConfig(t)
+	ctx := context.Background()
+	s := NewMemoryStore()
+	// a game with a DLC that has an expansion, and a version of the game
+	games := []*model.Game{
+		{Id: 1, Name: "Base", Dlcs: model.GameIds{2}},
+		{Id: 2, Name: "DLC", ParentGame: 1, Expansions: model.GameIds{3}},
+		{Id: 3, Name: "Expansion", ParentGame: 2},
+		{Id: 4, Name: "Edition", VersionParent: 1},
+	}
+	if err := s.SaveGames(ctx, games); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		depth     int
+		truncated bool
+	}{
+		{1, true},
+		{2, false},
+		{3, false},
+	}
+	for _, tt := range tests {
+		family, err := GetGameFamily(ctx, s, 3, tt.depth)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if family.Root.Id != 1 {
+			t.Errorf("depth %d: root = %d, want the base game 1", tt.depth, family.Root.Id)
+		}
+		if family.Truncated != tt.truncated {
+			t.Errorf("depth %d: truncated = %v, want %v", tt.depth, family.Truncated, tt.truncated)
+		}
+	}
+}
@@ -0,0 +1,843 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"igdb-database/model"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MemoryStore is a Store that keeps everything in memory. It is meant for
+// tests and small deployments, nothing is persisted.
+//
+// Items are kept as encoded documents, so callers never share memory with
+// the store.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	items      map[endpoint.Name]map[uint64]bson.Raw
+	games      map[uint64]bson.Raw
+	syncStates map[endpoint.Name]bson.Raw
+	runs       map[string]bson.Raw
+	views      map[string]map[uint64]bson.Raw
+	// events and deadLetters hold the webhook queue, keyed by entity and
+	// ordered by failure time
+	events      map[webhookEntity]model.WebhookEvent
+	deadLetters []model.WebhookEvent
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		items:      make(map[endpoint.Name]map[uint64]bson.Raw),
+		games:      make(map[uint64]bson.Raw),
+		syncStates: make(map[endpoint.Name]bson.Raw),
+		runs:       make(map[string]bson.Raw),
+		views:      make(map[string]map[uint64]bson.Raw),
+		events:     make(map[webhookEntity]model.WebhookEvent),
+	}
+}
+
+func (s *MemoryStore) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *MemoryStore) SaveItem(ctx context.Context, e endpoint.Name, item IdGetter) error {
+	return s.SaveItems(ctx, e, []IdGetter{item})
+}
+
+func (s *MemoryStore) SaveItems(ctx context.Context, e endpoint.Name, items []IdGetter) error {
+	raws := make([]bson.Raw, 0, len(items))
+	for _, item := range items {
+		raw, err := encodeDocument(item)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", string(e), err)
+		}
+		raws = append(raws, raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll := s.items[e]
+	if coll == nil {
+		coll = make(map[uint64]bson.Raw)
+		s.items[e] = coll
+	}
+	for i, item := range items {
+		raw := raws[i]
+		if old, ok := coll[item.GetId()]; ok {
+			var err error
+			raw, err = mergeDocument(old, raw)
+			if err != nil {
+				return fmt.Errorf("failed to merge %s %d: %w", string(e), item.GetId(), err)
+			}
+		}
+		coll[item.GetId()] = raw
+	}
+	return nil
+}
+
+// mergeDocument sets the top-level fields of update in doc, like the $set
+// of MongoDB.SaveItems, so fields missing from update are kept.
+func mergeDocument(doc bson.Raw, update bson.Raw) (bson.Raw, error) {
+	var merged, fields bson.D
+	if err := bson.Unmarshal(doc, &merged); err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(update, &fields); err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		i := slices.IndexFunc(merged, func(e bson.E) bool { return e.Key == field.Key })
+		if i < 0 {
+			merged = append(merged, field)
+		} else {
+			merged[i] = field
+		}
+	}
+	return encodeDocument(merged)
+}
+
+func (s *MemoryStore) GetItemById(ctx context.Context, e endpoint.Name, id uint64) (bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	raw, ok := s.items[e][id]
+	if !ok {
+		return nil, fmt.Errorf("failed to get item: %w", ErrNotFound)
+	}
+	return raw, nil
+}
+
+func (s *MemoryStore) GetItemsByIds(ctx context.Context, e endpoint.Name, ids []uint64) ([]bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	raws := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		if raw, ok := s.items[e][id]; ok {
+			raws = append(raws, raw)
+		}
+	}
+	return raws, nil
+}
+
+func (s *MemoryStore) GetItemsAfter(ctx context.Context, e endpoint.Name, afterId uint64, limit int64) ([]bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ids := slices.Sorted(maps.Keys(s.items[e]))
+	start, _ := slices.BinarySearch(ids, afterId+1)
+	ids = ids[start:min(start+int(limit), len(ids))]
+	raws := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		raws = append(raws, s.items[e][id])
+	}
+	return raws, nil
+}
+
+func (s *MemoryStore) GetLatestUpdatedAt(ctx context.Context, e endpoint.Name) (*timestamppb.Timestamp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.items[e]) == 0 {
+		return nil, fmt.Errorf("failed to get latest updated_at %s: %w", string(e), ErrNotFound)
+	}
+
+	var latest *timestamppb.Timestamp
+	for _, raw := range s.items[e] {
+		var item struct {
+			UpdatedAt *timestamppb.Timestamp `json:"updated_at"`
+		}
+		err := decodeDocument(raw, &item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", string(e), err)
+		}
+		if item.UpdatedAt != nil && (latest == nil || item.UpdatedAt.AsTime().After(latest.AsTime())) {
+			latest = item.UpdatedAt
+		}
+	}
+	return latest, nil
+}
+
+func (s *MemoryStore) CountDocuments(ctx context.Context, e endpoint.Name) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.items[e])), nil
+}
+
+func (s *MemoryStore) EstimatedDocumentCount(ctx context.Context, e endpoint.Name) (int64, error) {
+	return s.CountDocuments(ctx, e)
+}
+
+func (s *MemoryStore) GetExternalGames(ctx context.Context, sourceId uint64, uids []string) ([]bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	raws := []bson.Raw{}
+	for _, id := range slices.Sorted(maps.Keys(s.items[endpoint.EPExternalGames])) {
+		raw := s.items[endpoint.EPExternalGames][id]
+		var item struct {
+			Uid                string `json:"uid"`
+			ExternalGameSource *struct {
+				Id uint64 `json:"id"`
+			} `json:"external_game_source"`
+		}
+		err := decodeDocument(raw, &item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", string(endpoint.EPExternalGames), err)
+		}
+		if item.ExternalGameSource != nil && item.ExternalGameSource.Id == sourceId && slices.Contains(uids, item.Uid) {
+			raws = append(raws, raw)
+		}
+	}
+	return raws, nil
+}
+
+func (s *MemoryStore) RemoveItem(ctx context.Context, e endpoint.Name, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items[e], id)
+	return nil
+}
+
+func (s *MemoryStore) SaveGame(ctx context.Context, game *model.Game) error {
+	return s.SaveGames(ctx, []*model.Game{game})
+}
+
+func (s *MemoryStore) SaveGames(ctx context.Context, games []*model.Game) error {
+	raws := make([]bson.Raw, 0, len(games))
+	for _, game := range games {
+		raw, err := encodeDocument(game)
+		if err != nil {
+			return fmt.Errorf("failed to encode game: %w", err)
+		}
+		raws = append(raws, raw)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, game := range games {
+		s.games[game.Id] = raws[i]
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetGameById(ctx context.Context, id uint64) (*model.Game, error) {
+	s.mu.RLock()
+	raw, ok := s.games[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("failed to get game: %w", ErrNotFound)
+	}
+	return decodeGame(raw)
+}
+
+func (s *MemoryStore) GetGameBySlug(ctx context.Context, slug string) (*model.Game, error) {
+	games, err := s.allGames()
+	if err != nil {
+		return nil, err
+	}
+	for _, game := range games {
+		if game.Slug == slug {
+			return game, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to get game: %w", ErrNotFound)
+}
+
+func (s *MemoryStore) GetGamesByIds(ctx context.Context, ids []uint64) ([]*model.Game, error) {
+	s.mu.RLock()
+	raws := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		if raw, ok := s.games[id]; ok {
+			raws = append(raws, raw)
+		}
+	}
+	s.mu.RUnlock()
+
+	games := make([]*model.Game, 0, len(raws))
+	for _, raw := range raws {
+		game, err := decodeGame(raw)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+func (s *MemoryStore) IsGamesAggregated(ctx context.Context, ids []uint64) (map[uint64]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := s.games[id]; ok {
+			res[id] = true
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) CountGames(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.games)), nil
+}
+
+func (s *MemoryStore) GetGameSummaries(ctx context.Context, ids []uint64) ([]*model.GameSummary, error) {
+	games, err := s.GetGamesByIds(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]*model.GameSummary, 0, len(games))
+	for _, game := range games {
+		res = append(res, model.NewGameSummary(game))
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) GetFamilyGames(ctx context.Context, ids []uint64, parentIds []uint64) ([]*model.Game, error) {
+	games, err := s.allGames()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(games, func(game *model.Game) bool {
+		return !slices.Contains(ids, game.Id) &&
+			!slices.Contains(parentIds, uint64(game.ParentGame)) &&
+			!slices.Contains(parentIds, uint64(game.VersionParent))
+	}), nil
+}
+
+// SearchGames ranks all aggregated games, the memory store keeps no search
+// index.
+func (s *MemoryStore) SearchGames(ctx context.Context, query string, page int, pageSize int) (*model.SearchResult, error) {
+	q := normalizeName(query)
+	if q == "" {
+		return rankSearchHits(q, nil, page, pageSize), nil
+	}
+	games, err := s.allGames()
+	if err != nil {
+		return nil, err
+	}
+	return rankSearchHits(q, games, page, pageSize), nil
+}
+
+func (s *MemoryStore) PatchEmbeddedItem(ctx context.Context, e endpoint.Name, item IdGetter) (int64, error) {
+	id := item.GetId()
+	return patchEmbeddedItem(ctx, s, e, item, func(p embeddedPath) (int64, error) {
+		value, err := toDocument(item)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode %s: %w", string(e), err)
+		}
+		path := []string{p.field}
+		if p.nested != "" {
+			path = append(path, strings.Split(p.nested, ".")...)
+		}
+		return s.editGames(func(game bson.M) bool {
+			return editEmbedded(game, path, id, value)
+		})
+	})
+}
+
+func (s *MemoryStore) PatchRelatedGameSummary(ctx context.Context, summary *model.GameSummary) (int64, error) {
+	value, err := toDocument(summary)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode summary: %w", err)
+	}
+	return s.editGames(func(game bson.M) bool {
+		changed := false
+		for _, r := range relatedGames {
+			if editEmbedded(game, []string{"related", r.field}, summary.Id, value) {
+				changed = true
+			}
+		}
+		return changed
+	})
+}
+
+func (s *MemoryStore) RemoveFromGames(ctx context.Context, e endpoint.Name, id uint64) error {
+	if e == endpoint.EPGames {
+		return s.removeRelatedGame(id)
+	}
+
+	// games hold the references unexpanded, aggregated games also hold
+	// the copies expanded inside other items
+	refs, paths := [][]string{}, [][]string{}
+	for _, p := range gameEmbeddedPaths(e, expandDepth()) {
+		path := []string{p.field}
+		if p.nested == "" {
+			refs = append(refs, path)
+		} else {
+			path = append(path, strings.Split(p.nested, ".")...)
+		}
+		paths = append(paths, path)
+	}
+	remove := func(paths [][]string) func(doc bson.M) bool {
+		return func(doc bson.M) bool {
+			changed := false
+			for _, path := range paths {
+				if editEmbedded(doc, path, id, nil) {
+					changed = true
+				}
+			}
+			return changed
+		}
+	}
+	if _, err := s.editItems(endpoint.EPGames, remove(refs)); err != nil {
+		return fmt.Errorf("failed to remove %s %d from games: %w", string(e), id, err)
+	}
+	_, err := s.editGames(func(game bson.M) bool {
+		if !remove(paths)(game) {
+			return false
+		}
+		if e == endpoint.EPAlternativeNames {
+			// all_names is derived from the alternative names
+			names := bson.A{game["name"]}
+			if list, ok := game[embeddedListFields[e]].(bson.A); ok {
+				for _, item := range list {
+					if item, ok := item.(bson.M); ok {
+						names = append(names, item["name"])
+					}
+				}
+			}
+			game["all_names"] = names
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s %d from game_details: %w", string(e), id, err)
+	}
+	return nil
+}
+
+// removeRelatedGame deletes the aggregated game and strips its id from the
+// related games of other games.
+func (s *MemoryStore) removeRelatedGame(id uint64) error {
+	s.mu.Lock()
+	delete(s.games, id)
+	s.mu.Unlock()
+
+	fields := slices.Concat(relatedGameListFields, relatedGameSingleFields)
+	_, err := s.editItems(endpoint.EPGames, func(game bson.M) bool {
+		changed := false
+		for _, field := range fields {
+			if editEmbedded(game, []string{field}, id, nil) {
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove game %d from games: %w", id, err)
+	}
+	_, err = s.editGames(func(game bson.M) bool {
+		changed := false
+		for _, field := range fields {
+			if editEmbedded(game, []string{field}, id, nil) {
+				changed = true
+			}
+			if editEmbedded(game, []string{"related", field}, id, nil) {
+				changed = true
+			}
+		}
+		return changed
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove game %d from game_details: %w", id, err)
+	}
+	return nil
+}
+
+func (s *MemoryStore) SaveViewDocuments(ctx context.Context, view string, docs []bson.Raw) error {
+	ids := make([]uint64, 0, len(docs))
+	for _, doc := range docs {
+		id, err := documentId(doc)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coll := s.views[view]
+	if coll == nil {
+		coll = make(map[uint64]bson.Raw)
+		s.views[view] = coll
+	}
+	for i, doc := range docs {
+		coll[ids[i]] = doc
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetViewDocument(ctx context.Context, view string, id uint64) (bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	doc, ok := s.views[view][id]
+	if !ok {
+		return nil, fmt.Errorf("failed to get %s: %w", view, ErrNotFound)
+	}
+	return doc, nil
+}
+
+func (s *MemoryStore) GetViewDocuments(ctx context.Context, view string, ids []uint64) ([]bson.Raw, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		if doc, ok := s.views[view][id]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (s *MemoryStore) ViewDocumentIds(ctx context.Context, v View, e endpoint.Name, ids []uint64) ([]uint64, error) {
+	paths := v.Paths(e)
+	if len(paths) == 0 || len(ids) == 0 {
+		return nil, nil
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := []uint64{}
+	for _, docId := range slices.Sorted(maps.Keys(s.views[v.Name()])) {
+		doc, err := toDocument(s.views[v.Name()][docId])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", v.Name(), err)
+		}
+		if slices.ContainsFunc(paths, func(path string) bool {
+			return slices.ContainsFunc(lookupIds(doc, strings.Split(path, ".")), func(id uint64) bool {
+				return slices.Contains(ids, id)
+			})
+		}) {
+			res = append(res, docId)
+		}
+	}
+	return res, nil
+}
+
+func (s *MemoryStore) RemoveViewDocument(ctx context.Context, view string, id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.views[view], id)
+	return nil
+}
+
+// allGames returns all aggregated games sorted by id.
+func (s *MemoryStore) allGames() ([]*model.Game, error) {
+	s.mu.RLock()
+	ids := slices.Sorted(maps.Keys(s.games))
+	raws := make([]bson.Raw, 0, len(ids))
+	for _, id := range ids {
+		raws = append(raws, s.games[id])
+	}
+	s.mu.RUnlock()
+
+	games := make([]*model.Game, 0, len(raws))
+	for _, raw := range raws {
+		game, err := decodeGame(raw)
+		if err != nil {
+			return nil, err
+		}
+		games = append(games, game)
+	}
+	return games, nil
+}
+
+func (s *MemoryStore) GetSyncState(ctx context.Context, e endpoint.Name) (*model.SyncState, error) {
+	s.mu.RLock()
+	raw, ok := s.syncStates[e]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return decodeSyncState(raw)
+}
+
+func (s *MemoryStore) GetSyncStates(ctx context.Context) ([]*model.SyncState, error) {
+	s.mu.RLock()
+	names := slices.Sorted(maps.Keys(s.syncStates))
+	raws := make([]bson.Raw, 0, len(names))
+	for _, name := range names {
+		raws = append(raws, s.syncStates[name])
+	}
+	s.mu.RUnlock()
+
+	states := make([]*model.SyncState, 0, len(raws))
+	for _, raw := range raws {
+		state, err := decodeSyncState(raw)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+func (s *MemoryStore) StartSyncState(ctx context.Context, state *model.SyncState) error {
+	raw, err := encodeDocument(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncStates[state.Endpoint] = raw
+	return nil
+}
+
+func (s *MemoryStore) MarkPageCompleted(ctx context.Context, e endpoint.Name, offset uint64) error {
+	return s.updateSyncState(ctx, e, func(state *model.SyncState) {
+		if !slices.Contains(state.CompletedOffsets, offset) {
+			state.CompletedOffsets = append(state.CompletedOffsets, offset)
+		}
+		state.FailedOffsets = slices.DeleteFunc(state.FailedOffsets, func(o uint64) bool {
+			return o == offset
+		})
+	})
+}
+
+func (s *MemoryStore) MarkPageFailed(ctx context.Context, e endpoint.Name, offset uint64, pageErr error) error {
+	return s.updateSyncState(ctx, e, func(state *model.SyncState) {
+		if !slices.Contains(state.FailedOffsets, offset) {
+			state.FailedOffsets = append(state.FailedOffsets, offset)
+		}
+		state.LastError = pageErr.Error()
+	})
+}
+
+func (s *MemoryStore) FinishSyncState(ctx context.Context, e endpoint.Name) error {
+	return s.updateSyncState(ctx, e, func(state *model.SyncState) {
+		now := time.Now()
+		state.FinishedAt = &now
+	})
+}
+
+func (s *MemoryStore) updateSyncState(ctx context.Context, e endpoint.Name, update func(state *model.SyncState)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, ok := s.syncStates[e]
+	if !ok {
+		return nil
+	}
+	state, err := decodeSyncState(raw)
+	if err != nil {
+		return err
+	}
+	update(state)
+	raw, err = encodeDocument(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %w", err)
+	}
+	s.syncStates[e] = raw
+	return nil
+}
+
+func (s *MemoryStore) SaveRun(ctx context.Context, run *model.Run) error {
+	raw, err := encodeDocument(run)
+	if err != nil {
+		return fmt.Errorf("failed to encode run: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runs[run.Command] = raw
+	return nil
+}
+
+func (s *MemoryStore) GetRuns(ctx context.Context) ([]*model.Run, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	runs := make([]*model.Run, 0, len(s.runs))
+	for _, command := range slices.Sorted(maps.Keys(s.runs)) {
+		var run model.Run
+		if err := decodeDocument(s.runs[command], &run); err != nil {
+			return nil, fmt.Errorf("failed to decode run: %w", err)
+		}
+		runs = append(runs, &run)
+	}
+	return runs, nil
+}
+
+// editGames calls edit with every aggregated game decoded for editing and
+// stores the ones edit changed. It returns the number of changed games.
+func (s *MemoryStore) editGames(edit func(game bson.M) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return editDocuments(s.games, edit)
+}
+
+// editItems is editGames for the items of e.
+func (s *MemoryStore) editItems(e endpoint.Name, edit func(item bson.M) bool) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return editDocuments(s.items[e], edit)
+}
+
+func editDocuments(coll map[uint64]bson.Raw, edit func(doc bson.M) bool) (int64, error) {
+	changed := int64(0)
+	for id, raw := range coll {
+		doc, err := toDocument(raw)
+		if err != nil {
+			return changed, err
+		}
+		if !edit(doc) {
+			continue
+		}
+		raw, err = encodeDocument(doc)
+		if err != nil {
+			return changed, err
+		}
+		coll[id] = raw
+		changed++
+	}
+	return changed, nil
+}
+
+// toDocument converts v to a document whose nested documents are bson.M,
+// so it can be edited in place.
+func toDocument(v any) (bson.M, error) {
+	raw, ok := v.(bson.Raw)
+	if !ok {
+		var err error
+		raw, err = encodeDocument(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	dec := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	dec.DefaultDocumentM()
+	var doc bson.M
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// editEmbedded replaces the items with the given id at path in doc by
+// value, or removes them if value is nil. Lists along the path are walked
+// element by element, items are documents with an id or plain ids. It
+// reports whether doc changed.
+func editEmbedded(doc bson.M, path []string, id uint64, value bson.M) bool {
+	v, ok := doc[path[0]]
+	if !ok {
+		return false
+	}
+	if len(path) > 1 {
+		changed := false
+		switch v := v.(type) {
+		case bson.M:
+			changed = editEmbedded(v, path[1:], id, value)
+		case bson.A:
+			for _, elem := range v {
+				if elem, ok := elem.(bson.M); ok && editEmbedded(elem, path[1:], id, value) {
+					changed = true
+				}
+			}
+		}
+		return changed
+	}
+
+	if list, ok := v.(bson.A); ok {
+		changed := false
+		res := make(bson.A, 0, len(list))
+		for _, elem := range list {
+			if !isItem(elem, id) {
+				res = append(res, elem)
+				continue
+			}
+			changed = true
+			if value != nil {
+				res = append(res, value)
+			}
+		}
+		doc[path[0]] = res
+		return changed
+	}
+	if !isItem(v, id) {
+		return false
+	}
+	if value == nil {
+		delete(doc, path[0])
+	} else {
+		doc[path[0]] = value
+	}
+	return true
+}
+
+// lookupIds returns the ids at path in doc, walking lists along the path.
+func lookupIds(doc bson.M, path []string) []uint64 {
+	v, ok := doc[path[0]]
+	if !ok {
+		return nil
+	}
+	if len(path) > 1 {
+		switch v := v.(type) {
+		case bson.M:
+			return lookupIds(v, path[1:])
+		case bson.A:
+			ids := []uint64{}
+			for _, elem := range v {
+				if elem, ok := elem.(bson.M); ok {
+					ids = append(ids, lookupIds(elem, path[1:])...)
+				}
+			}
+			return ids
+		}
+		return nil
+	}
+	if list, ok := v.(bson.A); ok {
+		ids := make([]uint64, 0, len(list))
+		for _, elem := range list {
+			if id, ok := asId(elem); ok {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	}
+	if id, ok := asId(v); ok {
+		return []uint64{id}
+	}
+	return nil
+}
+
+// isItem reports whether v is the document of the item with the given id
+// or that id.
+func isItem(v any, id uint64) bool {
+	if doc, ok := v.(bson.M); ok {
+		v = doc["id"]
+	}
+	itemId, ok := asId(v)
+	return ok && itemId == id
+}
+
+func asId(v any) (uint64, bool) {
+	switch v := v.(type) {
+	case int32:
+		return uint64(v), true
+	case int64:
+		return uint64(v), true
+	}
+	return 0, false
+}
+
+func decodeGame(raw bson.Raw) (*model.Game, error) {
+	var game model.Game
+	err := decodeDocument(raw, &game)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode game: %w", err)
+	}
+	return &game, nil
+}
+
+func decodeSyncState(raw bson.Raw) (*model.SyncState, error) {
+	var state model.SyncState
+	err := decodeDocument(raw, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode sync state: %w", err)
+	}
+	return &state, nil
+}
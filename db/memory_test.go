@@ -0,0 +1,198 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"igdb-database/config"
+	"igdb-database/model"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("IGDB_STORAGE", "memory")
+	t.Setenv("IGDB_TWITCH_CLIENT_ID", "id")
+	t.Setenv("IGDB_TWITCH_CLIENT_SECRET", "secret")
+	if _, err := config.Load(""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestStore returns a memory store holding a game with a genre, an
+// alternative name and a company whose logo is two references deep.
+func newTestStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	ctx := context.Background()
+	s := NewMemoryStore()
+	save := func(e endpoint.Name, item IdGetter) {
+		if err := s.SaveItem(ctx, e, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(endpoint.EPGames, &pb.Game{
+		Id:                1,
+		Name:              "Zelda",
+		Genres:            []*pb.Genre{{Id: 10}},
+		AlternativeNames:  []*pb.AlternativeName{{Id: 20}},
+		InvolvedCompanies: []*pb.InvolvedCompany{{Id: 100}},
+	})
+	save(endpoint.EPGenres, &pb.Genre{Id: 10, Name: "Adventure"})
+	save(endpoint.EPAlternativeNames, &pb.AlternativeName{Id: 20, Name: "Zeruda"})
+	save(endpoint.EPInvolvedCompanies, &pb.InvolvedCompany{Id: 100, Company: &pb.Company{Id: 200}})
+	save(endpoint.EPCompanies, &pb.Company{Id: 200, Name: "Nintendo", Logo: &pb.CompanyLogo{Id: 300}})
+	save(endpoint.EPCompanyLogos, &pb.CompanyLogo{Id: 300, ImageId: "abc"})
+	return s
+}
+
+func aggregateTestGame(t *testing.T, s *MemoryStore) {
+	t.Helper()
+	ctx := context.Background()
+	game, err := GetItemById[pb.Game](ctx, s, endpoint.EPGames, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	games, err := ConvertGames(ctx, s, []*pb.Game{game}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveGames(ctx, games); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConvertGamesWithMemoryStore(t *testing.T) {
+	loadTestConfig(t)
+	s := newTestStore(t)
+	aggregateTestGame(t, s)
+
+	game, err := s.GetGameById(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Genres) != 1 || game.Genres[0].Name != "Adventure" {
+		t.Errorf("genres = %v, want Adventure", game.Genres)
+	}
+	if !slices.Equal(game.AllNames, []string{"Zelda", "Zeruda"}) {
+		t.Errorf("all names = %v, want [Zelda Zeruda]", game.AllNames)
+	}
+	if len(game.InvolvedCompanies) != 1 || game.InvolvedCompanies[0].GetCompany().GetLogo().GetImageId() != "abc" {
+		t.Errorf("involved companies = %v, want the expanded company logo abc", game.InvolvedCompanies)
+	}
+}
+
+func TestMemoryStorePatchEmbeddedItem(t *testing.T) {
+	loadTestConfig(t)
+	s := newTestStore(t)
+	aggregateTestGame(t, s)
+	ctx := context.Background()
+
+	patched, err := s.PatchEmbeddedItem(ctx, endpoint.EPCompanyLogos, &pb.CompanyLogo{Id: 300, ImageId: "def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patched != 1 {
+		t.Errorf("patched = %d, want 1", patched)
+	}
+	game, err := s.GetGameById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logo := game.InvolvedCompanies[0].GetCompany().GetLogo(); logo.GetImageId() != "def" {
+		t.Errorf("company logo = %v, want image def", logo)
+	}
+}
+
+func TestMemoryStoreRemoveFromGames(t *testing.T) {
+	loadTestConfig(t)
+	s := newTestStore(t)
+	aggregateTestGame(t, s)
+	ctx := context.Background()
+
+	if err := s.RemoveFromGames(ctx, endpoint.EPGenres, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveFromGames(ctx, endpoint.EPAlternativeNames, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RemoveFromGames(ctx, endpoint.EPCompanyLogos, 300); err != nil {
+		t.Fatal(err)
+	}
+	game, err := s.GetGameById(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(game.Genres) != 0 {
+		t.Errorf("genres = %v, want none", game.Genres)
+	}
+	if !slices.Equal(game.AllNames, []string{"Zelda"}) {
+		t.Errorf("all names = %v, want [Zelda]", game.AllNames)
+	}
+	if len(game.InvolvedCompanies) != 1 || game.InvolvedCompanies[0].GetCompany().GetLogo() != nil {
+		t.Errorf("involved companies = %v, want the company without logo", game.InvolvedCompanies)
+	}
+	stored, err := GetItemById[pb.Game](ctx, s, endpoint.EPGames, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Genres) != 0 {
+		t.Errorf("stored game genres = %v, want none", stored.Genres)
+	}
+}
+
+func TestMemoryStoreGetDeadLetters(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	for id := uint64(1); id <= 3; id++ {
+		err := s.EnqueueWebhookEvent(ctx, &model.WebhookEvent{Endpoint: endpoint.EPGenres, Method: model.WebhookMethodUpsert, EntityId: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+		event, err := s.ClaimWebhookEvent(ctx, time.Minute)
+		if err != nil || event == nil {
+			t.Fatalf("claim: event %v, err %v", event, err)
+		}
+		if err := s.DeadLetterWebhookEvent(ctx, event, errors.New("failed")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, err := s.GetDeadLetters(ctx, bson.ObjectID{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 2 || first[0].EntityId != 3 || first[1].EntityId != 2 {
+		t.Fatalf("first page = %v, want entities 3 and 2", first)
+	}
+	second, err := s.GetDeadLetters(ctx, first[1].MId, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second) != 1 || second[0].EntityId != 1 {
+		t.Errorf("second page = %v, want entity 1", second)
+	}
+}
+
+func TestMemoryStoreSaveItemsMerges(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if err := s.SaveItem(ctx, endpoint.EPGenres, &pb.Genre{Id: 10, Name: "Adventure", Slug: "adventure"}); err != nil {
+		t.Fatal(err)
+	}
+	// a partial payload keeps the fields it does not hold, like $set
+	if err := s.SaveItem(ctx, endpoint.EPGenres, &pb.Genre{Id: 10, Name: "Action"}); err != nil {
+		t.Fatal(err)
+	}
+	genre, err := GetItemById[pb.Genre](ctx, s, endpoint.EPGenres, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genre.Name != "Action" || genre.Slug != "adventure" {
+		t.Errorf("genre = %v, want name Action and the kept slug adventure", genre)
+	}
+}
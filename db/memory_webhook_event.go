@@ -0,0 +1,183 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"igdb-database/model"
+	"slices"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// webhookEntity is the key of the queued event of an entity.
+type webhookEntity struct {
+	endpoint endpoint.Name
+	id       uint64
+}
+
+func (s *MemoryStore) EnqueueWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	now := time.Now()
+	key := webhookEntity{event.Endpoint, event.EntityId}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued, ok := s.events[key]
+	if !ok {
+		queued = model.WebhookEvent{
+			MId:         bson.NewObjectID(),
+			Endpoint:    event.Endpoint,
+			EntityId:    event.EntityId,
+			Status:      model.WebhookEventPending,
+			LockedUntil: now,
+			CreatedAt:   now,
+		}
+	}
+	queued.Method = event.Method
+	queued.RequestId = event.RequestId
+	queued.NextAttemptAt = now
+	queued.Version++
+	s.events[key] = queued
+	return nil
+}
+
+func (s *MemoryStore) ClaimWebhookEvent(ctx context.Context, lockFor time.Duration) (*model.WebhookEvent, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next *webhookEntity
+	for key, event := range s.events {
+		due := (event.Status == model.WebhookEventPending && !event.NextAttemptAt.After(now)) ||
+			(event.Status == model.WebhookEventProcessing && !event.LockedUntil.After(now))
+		if due && (next == nil || event.NextAttemptAt.Before(s.events[*next].NextAttemptAt)) {
+			next = &key
+		}
+	}
+	if next == nil {
+		return nil, nil
+	}
+
+	event := s.events[*next]
+	if event.Status == model.WebhookEventProcessing {
+		event.Attempts++
+	}
+	event.Status = model.WebhookEventProcessing
+	event.LockedUntil = now.Add(lockFor)
+	s.events[*next] = event
+	return &event, nil
+}
+
+// ownedEvent returns the key of event if the worker that claimed it still
+// holds the lock.
+func (s *MemoryStore) ownedEvent(event *model.WebhookEvent) (webhookEntity, bool) {
+	key := webhookEntity{event.Endpoint, event.EntityId}
+	queued, ok := s.events[key]
+	return key, ok && queued.MId == event.MId &&
+		queued.Status == model.WebhookEventProcessing &&
+		queued.LockedUntil.Equal(event.LockedUntil)
+}
+
+func (s *MemoryStore) CompleteWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.completeWebhookEvent(event)
+}
+
+func (s *MemoryStore) completeWebhookEvent(event *model.WebhookEvent) error {
+	key, ok := s.ownedEvent(event)
+	if !ok {
+		return ErrWebhookLockLost
+	}
+	queued := s.events[key]
+	if queued.Version == event.Version {
+		delete(s.events, key)
+		return nil
+	}
+	queued.Status = model.WebhookEventPending
+	queued.Attempts = 0
+	queued.NextAttemptAt = time.Now()
+	queued.LastError = ""
+	s.events[key] = queued
+	return nil
+}
+
+func (s *MemoryStore) RetryWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.ownedEvent(event)
+	if !ok {
+		return ErrWebhookLockLost
+	}
+	queued := s.events[key]
+	queued.Status = model.WebhookEventPending
+	queued.LastError = eventErr.Error()
+	queued.NextAttemptAt = next
+	queued.Attempts++
+	s.events[key] = queued
+	return nil
+}
+
+func (s *MemoryStore) DeadLetterWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.completeWebhookEvent(event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	failed := *event
+	failed.MId = bson.NewObjectID()
+	failed.Attempts++
+	failed.LastError = eventErr.Error()
+	failed.FailedAt = &now
+	s.deadLetters = append(s.deadLetters, failed)
+	return nil
+}
+
+func (s *MemoryStore) GetDeadLetters(ctx context.Context, after bson.ObjectID, limit int64) ([]*model.WebhookEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	events := []*model.WebhookEvent{}
+	// dead letters are appended in failure order, the newest come first
+	for i := len(s.deadLetters) - 1; i >= 0 && len(events) < int(limit); i-- {
+		event := s.deadLetters[i]
+		if !after.IsZero() && bytes.Compare(event.MId[:], after[:]) >= 0 {
+			continue
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+func (s *MemoryStore) ReplayDeadLetters(ctx context.Context, ids []bson.ObjectID) (int, error) {
+	s.mu.Lock()
+	replay := []model.WebhookEvent{}
+	s.deadLetters = slices.DeleteFunc(s.deadLetters, func(event model.WebhookEvent) bool {
+		if len(ids) > 0 && !slices.Contains(ids, event.MId) {
+			return false
+		}
+		replay = append(replay, event)
+		return true
+	})
+	s.mu.Unlock()
+
+	for _, event := range replay {
+		err := s.EnqueueWebhookEvent(ctx, &event)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(replay), nil
+}
+
+func (s *MemoryStore) CountWebhookEvents(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.events)), nil
+}
+
+func (s *MemoryStore) CountDeadLetters(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int64(len(s.deadLetters)), nil
+}
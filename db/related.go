@@ -0,0 +1,156 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/model"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// relatedGame is a related game field of model.Game that is summarized in
+// model.RelatedGames.
+type relatedGame struct {
+	field string
+	list  bool
+	get   func(game *pb.Game) []*pb.Game
+	set   func(res *model.RelatedGames, summaries []*model.GameSummary)
+}
+
+var relatedGames = []relatedGame{
+	{"bundles", true, (*pb.Game).GetBundles, func(r *model.RelatedGames, v []*model.GameSummary) { r.Bundles = v }},
+	{"dlcs", true, (*pb.Game).GetDlcs, func(r *model.RelatedGames, v []*model.GameSummary) { r.Dlcs = v }},
+	{"expanded_games", true, (*pb.Game).GetExpandedGames, func(r *model.RelatedGames, v []*model.GameSummary) { r.ExpandedGames = v }},
+	{"expansions", true, (*pb.Game).GetExpansions, func(r *model.RelatedGames, v []*model.GameSummary) { r.Expansions = v }},
+	{"forks", true, (*pb.Game).GetForks, func(r *model.RelatedGames, v []*model.GameSummary) { r.Forks = v }},
+	{"parent_game", false, singleGame((*pb.Game).GetParentGame), func(r *model.RelatedGames, v []*model.GameSummary) { r.ParentGame = v[0] }},
+	{"ports", true, (*pb.Game).GetPorts, func(r *model.RelatedGames, v []*model.GameSummary) { r.Ports = v }},
+	{"remakes", true, (*pb.Game).GetRemakes, func(r *model.RelatedGames, v []*model.GameSummary) { r.Remakes = v }},
+	{"remasters", true, (*pb.Game).GetRemasters, func(r *model.RelatedGames, v []*model.GameSummary) { r.Remasters = v }},
+	{"similar_games", true, (*pb.Game).GetSimilarGames, func(r *model.RelatedGames, v []*model.GameSummary) { r.SimilarGames = v }},
+	{"standalone_expansions", true, (*pb.Game).GetStandaloneExpansions, func(r *model.RelatedGames, v []*model.GameSummary) { r.StandaloneExpansions = v }},
+	{"version_parent", false, singleGame((*pb.Game).GetVersionParent), func(r *model.RelatedGames, v []*model.GameSummary) { r.VersionParent = v[0] }},
+}
+
+func singleGame(get func(game *pb.Game) *pb.Game) func(game *pb.Game) []*pb.Game {
+	return func(game *pb.Game) []*pb.Game {
+		if g := get(game); g != nil {
+			return []*pb.Game{g}
+		}
+		return nil
+	}
+}
+
+// resolveRelatedGames sets the summaries of the related games of res. They
+// are built from the stored games, covers and game types, so they do not
+// depend on the order games are aggregated in.
+func resolveRelatedGames(ctx context.Context, s Store, client *igdb.Client, games []*pb.Game, res []*model.Game) error {
+	ids := []uint64{}
+	for _, game := range games {
+		for _, r := range relatedGames {
+			for _, g := range r.get(game) {
+				ids = append(ids, g.Id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var gameFetcher endpoint.EntityEndpoint[pb.Game]
+	var coverFetcher endpoint.EntityEndpoint[pb.Cover]
+	var typeFetcher endpoint.EntityEndpoint[pb.GameType]
+	if client != nil {
+		gameFetcher, coverFetcher, typeFetcher = client.Games, client.Covers, client.GameTypes
+	}
+	related, err := resolveItems(ctx, s, endpoint.EPGames, gameFetcher, ids)
+	if err != nil {
+		return fmt.Errorf("failed to resolve related games: %w", err)
+	}
+	coverIds := make([]uint64, 0, len(related))
+	typeIds := make([]uint64, 0, len(related))
+	for _, g := range related {
+		if g.Cover != nil {
+			coverIds = append(coverIds, g.Cover.Id)
+		}
+		if g.GameType != nil {
+			typeIds = append(typeIds, g.GameType.Id)
+		}
+	}
+	covers, err := resolveItems(ctx, s, endpoint.EPCovers, coverFetcher, coverIds)
+	if err != nil {
+		return fmt.Errorf("failed to resolve covers of related games: %w", err)
+	}
+	types, err := resolveItems(ctx, s, endpoint.EPGameTypes, typeFetcher, typeIds)
+	if err != nil {
+		return fmt.Errorf("failed to resolve game types of related games: %w", err)
+	}
+
+	summaries := make(map[uint64]*model.GameSummary, len(related))
+	for id, g := range related {
+		game := &model.Game{
+			Id:               g.Id,
+			Name:             g.Name,
+			Slug:             g.Slug,
+			FirstReleaseDate: g.FirstReleaseDate,
+		}
+		if g.Cover != nil {
+			game.Cover = covers[g.Cover.Id]
+		}
+		if g.GameType != nil {
+			game.GameType = types[g.GameType.Id]
+		}
+		summaries[id] = model.NewGameSummary(game)
+	}
+
+	for i, game := range games {
+		for _, r := range relatedGames {
+			refs := r.get(game)
+			if len(refs) == 0 {
+				continue
+			}
+			list := make([]*model.GameSummary, 0, len(refs))
+			for _, ref := range refs {
+				if summary, ok := summaries[ref.Id]; ok {
+					list = append(list, summary)
+				} else {
+					list = append(list, &model.GameSummary{Id: ref.Id})
+				}
+			}
+			if res[i].Related == nil {
+				res[i].Related = &model.RelatedGames{}
+			}
+			r.set(res[i].Related, list)
+		}
+	}
+	return nil
+}
+
+// PatchRelatedGameSummary replaces the summary of a game in the related
+// games of every aggregated game and returns the number of patched games.
+func (m *MongoDB) PatchRelatedGameSummary(ctx context.Context, summary *model.GameSummary) (int64, error) {
+	models := make([]mongo.WriteModel, 0, len(relatedGames))
+	for _, r := range relatedGames {
+		field := "related." + r.field
+		filter := bson.M{field + ".id": summary.Id}
+		if r.list {
+			models = append(models, mongo.NewUpdateManyModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{"$set": bson.M{field + ".$[item]": summary}}).
+				SetArrayFilters([]any{bson.M{"item.id": summary.Id}}))
+		} else {
+			models = append(models, mongo.NewUpdateManyModel().
+				SetFilter(filter).
+				SetUpdate(bson.M{"$set": bson.M{field: summary}}))
+		}
+	}
+	res, err := m.GameCollection.BulkWrite(ctx, models)
+	if err != nil {
+		return 0, fmt.Errorf("failed to patch summary of game %d in game_details: %w", summary.Id, err)
+	}
+	return res.ModifiedCount, nil
+}
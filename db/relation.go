@@ -0,0 +1,243 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"igdb-database/model"
+	"slices"
+	"time"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+)
+
+// relation is a relation of model.Game that is resolved from the ids
+// referenced by pb.Game.
+type relation interface {
+	endpointName() endpoint.Name
+	// resolve sets the relation of res and adds the resolved items to
+	// embedded, so their references can be expanded.
+	resolve(ctx context.Context, s Store, client *igdb.Client, games []*pb.Game, res []*model.Game, embedded map[endpoint.Name][]any) error
+}
+
+// maxIgdbIds is the maximum number of ids IGDB returns in one request.
+const maxIgdbIds = 500
+
+// gameRelations declares the relations of model.Game. Items are read from the
+// store, missing items are fetched from IGDB and saved.
+var gameRelations = []relation{
+	listRelation(endpoint.EPAgeRatings, (*pb.Game).GetAgeRatings, func(c *igdb.Client) endpoint.EntityEndpoint[pb.AgeRating] { return c.AgeRatings }, func(g *model.Game, v []*pb.AgeRating) { g.AgeRatings = v }),
+	listRelation(endpoint.EPAlternativeNames, (*pb.Game).GetAlternativeNames, func(c *igdb.Client) endpoint.EntityEndpoint[pb.AlternativeName] { return c.AlternativeNames }, func(g *model.Game, v []*pb.AlternativeName) { g.AlternativeNames = v }),
+	listRelation(endpoint.EPArtworks, (*pb.Game).GetArtworks, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Artwork] { return c.Artworks }, func(g *model.Game, v []*pb.Artwork) { g.Artworks = v }),
+	singleRelation(endpoint.EPCovers, (*pb.Game).GetCover, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Cover] { return c.Covers }, func(g *model.Game, v *pb.Cover) { g.Cover = v }),
+	listRelation(endpoint.EPExternalGames, (*pb.Game).GetExternalGames, func(c *igdb.Client) endpoint.EntityEndpoint[pb.ExternalGame] { return c.ExternalGames }, func(g *model.Game, v []*pb.ExternalGame) { g.ExternalGames = v }),
+	singleRelation(endpoint.EPFranchises, (*pb.Game).GetFranchise, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Franchise] { return c.Franchises }, func(g *model.Game, v *pb.Franchise) { g.Franchise = v }),
+	listRelation(endpoint.EPFranchises, (*pb.Game).GetFranchises, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Franchise] { return c.Franchises }, func(g *model.Game, v []*pb.Franchise) { g.Franchises = v }),
+	listRelation(endpoint.EPGameEngines, (*pb.Game).GetGameEngines, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameEngine] { return c.GameEngines }, func(g *model.Game, v []*pb.GameEngine) { g.GameEngines = v }),
+	listRelation(endpoint.EPGameModes, (*pb.Game).GetGameModes, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameMode] { return c.GameModes }, func(g *model.Game, v []*pb.GameMode) { g.GameModes = v }),
+	listRelation(endpoint.EPGenres, (*pb.Game).GetGenres, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Genre] { return c.Genres }, func(g *model.Game, v []*pb.Genre) { g.Genres = v }),
+	listRelation(endpoint.EPInvolvedCompanies, (*pb.Game).GetInvolvedCompanies, func(c *igdb.Client) endpoint.EntityEndpoint[pb.InvolvedCompany] { return c.InvolvedCompanies }, func(g *model.Game, v []*pb.InvolvedCompany) { g.InvolvedCompanies = v }),
+	listRelation(endpoint.EPKeywords, (*pb.Game).GetKeywords, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Keyword] { return c.Keywords }, func(g *model.Game, v []*pb.Keyword) { g.Keywords = v }),
+	listRelation(endpoint.EPMultiplayerModes, (*pb.Game).GetMultiplayerModes, func(c *igdb.Client) endpoint.EntityEndpoint[pb.MultiplayerMode] { return c.MultiplayerModes }, func(g *model.Game, v []*pb.MultiplayerMode) { g.MultiplayerModes = v }),
+	listRelation(endpoint.EPPlatforms, (*pb.Game).GetPlatforms, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Platform] { return c.Platforms }, func(g *model.Game, v []*pb.Platform) { g.Platforms = v }),
+	listRelation(endpoint.EPPlayerPerspectives, (*pb.Game).GetPlayerPerspectives, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlayerPerspective] { return c.PlayerPerspectives }, func(g *model.Game, v []*pb.PlayerPerspective) { g.PlayerPerspectives = v }),
+	listRelation(endpoint.EPReleaseDates, (*pb.Game).GetReleaseDates, func(c *igdb.Client) endpoint.EntityEndpoint[pb.ReleaseDate] { return c.ReleaseDates }, func(g *model.Game, v []*pb.ReleaseDate) { g.ReleaseDates = v }),
+	listRelation(endpoint.EPScreenshots, (*pb.Game).GetScreenshots, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Screenshot] { return c.Screenshots }, func(g *model.Game, v []*pb.Screenshot) { g.Screenshots = v }),
+	listRelation(endpoint.EPThemes, (*pb.Game).GetThemes, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Theme] { return c.Themes }, func(g *model.Game, v []*pb.Theme) { g.Themes = v }),
+	listRelation(endpoint.EPGameVideos, (*pb.Game).GetVideos, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameVideo] { return c.GameVideos }, func(g *model.Game, v []*pb.GameVideo) { g.Videos = v }),
+	listRelation(endpoint.EPWebsites, (*pb.Game).GetWebsites, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Website] { return c.Websites }, func(g *model.Game, v []*pb.Website) { g.Websites = v }),
+	listRelation(endpoint.EPLanguageSupports, (*pb.Game).GetLanguageSupports, func(c *igdb.Client) endpoint.EntityEndpoint[pb.LanguageSupport] { return c.LanguageSupports }, func(g *model.Game, v []*pb.LanguageSupport) { g.LanguageSupports = v }),
+	listRelation(endpoint.EPGameLocalizations, (*pb.Game).GetGameLocalizations, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameLocalization] { return c.GameLocalizations }, func(g *model.Game, v []*pb.GameLocalization) { g.GameLocalizations = v }),
+	listRelation(endpoint.EPCollections, (*pb.Game).GetCollections, func(c *igdb.Client) endpoint.EntityEndpoint[pb.Collection] { return c.Collections }, func(g *model.Game, v []*pb.Collection) { g.Collections = v }),
+	singleRelation(endpoint.EPGameStatuses, (*pb.Game).GetGameStatus, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameStatus] { return c.GameStatuses }, func(g *model.Game, v *pb.GameStatus) { g.GameStatus = v }),
+	singleRelation(endpoint.EPGameTypes, (*pb.Game).GetGameType, func(c *igdb.Client) endpoint.EntityEndpoint[pb.GameType] { return c.GameTypes }, func(g *model.Game, v *pb.GameType) { g.GameType = v }),
+}
+
+// itemRelation resolves the items of type T referenced by a game.
+type itemRelation[T any] struct {
+	name  endpoint.Name
+	ids   func(game *pb.Game) []uint64
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[T]
+	set   func(res *model.Game, items []*T)
+}
+
+func listRelation[T any](
+	name endpoint.Name,
+	get func(game *pb.Game) []*T,
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[T],
+	set func(res *model.Game, items []*T),
+) relation {
+	return &itemRelation[T]{
+		name: name,
+		ids: func(game *pb.Game) []uint64 {
+			items := get(game)
+			ids := make([]uint64, 0, len(items))
+			for _, item := range items {
+				ids = append(ids, any(item).(IdGetter).GetId())
+			}
+			return ids
+		},
+		fetch: fetch,
+		set:   set,
+	}
+}
+
+func singleRelation[T any](
+	name endpoint.Name,
+	get func(game *pb.Game) *T,
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[T],
+	set func(res *model.Game, item *T),
+) relation {
+	return &itemRelation[T]{
+		name: name,
+		ids: func(game *pb.Game) []uint64 {
+			item := get(game)
+			if item == nil {
+				return nil
+			}
+			return []uint64{any(item).(IdGetter).GetId()}
+		},
+		fetch: fetch,
+		set: func(res *model.Game, items []*T) {
+			if len(items) > 0 {
+				set(res, items[0])
+			}
+		},
+	}
+}
+
+func (r *itemRelation[T]) endpointName() endpoint.Name {
+	return r.name
+}
+
+// resolve resolves the relation for all games at once, res[i] is the
+// aggregated games[i].
+func (r *itemRelation[T]) resolve(ctx context.Context, s Store, client *igdb.Client, games []*pb.Game, res []*model.Game, embedded map[endpoint.Name][]any) error {
+	idsByGame := make([][]uint64, len(games))
+	allIds := []uint64{}
+	for i, game := range games {
+		idsByGame[i] = uniqueIds(r.ids(game))
+		allIds = append(allIds, idsByGame[i]...)
+	}
+
+	var fetcher endpoint.EntityEndpoint[T]
+	if client != nil {
+		fetcher = r.fetch(client)
+	}
+	found, err := resolveItems(ctx, s, r.name, fetcher, allIds)
+	if err != nil {
+		return err
+	}
+	for _, item := range found {
+		embedded[r.name] = append(embedded[r.name], item)
+	}
+
+	for i, ids := range idsByGame {
+		if len(ids) == 0 {
+			continue
+		}
+		items := make([]*T, 0, len(ids))
+		for _, id := range ids {
+			if item, ok := found[id]; ok {
+				items = append(items, item)
+			}
+		}
+		r.set(res[i], items)
+	}
+	return nil
+}
+
+// resolveItems returns the items with the given ids by id. Items that are
+// not stored are fetched from IGDB in batches and saved, ids unknown to IGDB
+// are skipped. Without a fetcher missing items are skipped as well.
+func resolveItems[T any](
+	ctx context.Context,
+	s Store,
+	e endpoint.Name,
+	fetcher endpoint.EntityEndpoint[T],
+	ids []uint64,
+) (map[uint64]*T, error) {
+	ids = uniqueIds(ids)
+	found := make(map[uint64]*T, len(ids))
+	if len(ids) == 0 {
+		return found, nil
+	}
+
+	stored, err := GetItemsByIds[T](ctx, s, e, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range stored {
+		found[any(item).(IdGetter).GetId()] = item
+	}
+
+	missingIds := make([]uint64, 0, len(ids)-len(found))
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missingIds = append(missingIds, id)
+		}
+	}
+	if fetcher == nil {
+		return found, nil
+	}
+	if len(missingIds) > 0 {
+		logging.From(ctx).Debug("fetching missing items from igdb", logging.KeyEndpoint, e, "items", len(missingIds))
+	}
+	for i := 0; i < len(missingIds); i += maxIgdbIds {
+		start := time.Now()
+		fetched, err := fetcher.GetByIDs(missingIds[i:min(i+maxIgdbIds, len(missingIds))])
+		metrics.IgdbRequest(string(e), "get_by_ids", start, err)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get %s from igdb: %w", string(e), err)
+		}
+		err = SaveItems(ctx, s, e, fetched)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range fetched {
+			found[any(item).(IdGetter).GetId()] = item
+		}
+	}
+	return found, nil
+}
+
+func uniqueIds(ids []uint64) []uint64 {
+	seen := make(map[uint64]bool, len(ids))
+	res := make([]uint64, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
+}
+
+// gameIds returns the ids of related games.
+func gameIds(games []*pb.Game) model.GameIds {
+	ids := make(model.GameIds, 0, len(games))
+	for _, g := range games {
+		ids = append(ids, g.Id)
+	}
+	return ids
+}
+
+// GameRelationEndpoints returns the endpoints ConvertGame reads the relations
+// of games and their expanded references from.
+func GameRelationEndpoints() []endpoint.Name {
+	names := make([]endpoint.Name, 0, len(gameRelations)+len(gameExpansions))
+	for _, r := range gameRelations {
+		if !slices.Contains(names, r.endpointName()) {
+			names = append(names, r.endpointName())
+		}
+	}
+	for _, x := range gameExpansions {
+		if !slices.Contains(names, x.endpointName()) {
+			names = append(names, x.endpointName())
+		}
+	}
+	return names
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/model"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// SaveRun replaces the previous run of the same command.
+func (m *MongoDB) SaveRun(ctx context.Context, run *model.Run) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := m.RunCollection.ReplaceOne(ctx, bson.M{"command": run.Command}, run, opts)
+	if err != nil {
+		return fmt.Errorf("failed to save run %s: %w", run.Command, err)
+	}
+	return nil
+}
+
+// GetRuns returns the last run of every command.
+func (m *MongoDB) GetRuns(ctx context.Context) ([]*model.Run, error) {
+	cursor, err := m.RunCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"command": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runs: %w", err)
+	}
+	var runs []*model.Run
+	err = cursor.All(ctx, &runs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get runs: %w", err)
+	}
+	return runs, nil
+}
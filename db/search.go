@@ -0,0 +1,339 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/model"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+const (
+	searchCandidateLimit = 200
+	searchMaxResults     = 500
+	// minSearchKeyLength is the length of the shortest prefix key.
+	minSearchKeyLength = 2
+)
+
+var searchProjection = bson.M{
+	"id":                 1,
+	"name":               1,
+	"slug":               1,
+	"all_names":          1,
+	"cover":              1,
+	"first_release_date": 1,
+	"game_type":          1,
+	"total_rating_count": 1,
+	"hypes":              1,
+}
+
+// SearchGames ranks aggregated games by how well any of their AllNames
+// matches the query. Exact matches rank above prefix matches, prefix above
+// token matches and token above typo-tolerant matches; popularity is used
+// as a boost within those tiers. Only the best searchMaxResults hits are
+// ranked, so Total is capped at that.
+func (m *MongoDB) SearchGames(ctx context.Context, query string, page int, pageSize int) (*model.SearchResult, error) {
+	q := normalizeName(query)
+	if q == "" {
+		return rankSearchHits(q, nil, page, pageSize), nil
+	}
+	candidates, err := m.searchCandidates(ctx, query, q)
+	if err != nil {
+		return nil, err
+	}
+	return rankSearchHits(q, candidates, page, pageSize), nil
+}
+
+// rankSearchHits scores the candidates against the normalized query q and
+// returns the requested page of the best searchMaxResults hits.
+func rankSearchHits(q string, candidates []*model.Game, page int, pageSize int) *model.SearchResult {
+	if page < 1 {
+		page = 1
+	}
+	res := &model.SearchResult{
+		Page:     page,
+		PageSize: pageSize,
+		Hits:     []*model.SearchHit{},
+	}
+
+	type rankedHit struct {
+		hit     *model.SearchHit
+		tier    int
+		boosted float64
+	}
+	ranked := make([]rankedHit, 0, len(candidates))
+	for _, game := range candidates {
+		score, matched := scoreGame(q, game)
+		if score <= 0 {
+			continue
+		}
+		ranked = append(ranked, rankedHit{
+			hit: &model.SearchHit{
+				GameSummary: model.NewGameSummary(game),
+				MatchedName: matched,
+				Score:       score,
+			},
+			tier:    matchTier(score),
+			boosted: score * popularityBoost(game),
+		})
+	}
+	// popularity only orders the hits of a tier, a popular prefix match
+	// never outranks an exact one
+	slices.SortFunc(ranked, func(a, b rankedHit) int {
+		if a.tier != b.tier {
+			return b.tier - a.tier
+		}
+		if a.boosted != b.boosted {
+			if a.boosted > b.boosted {
+				return -1
+			}
+			return 1
+		}
+		if a.hit.Id < b.hit.Id {
+			return -1
+		}
+		if a.hit.Id > b.hit.Id {
+			return 1
+		}
+		return 0
+	})
+	hits := make([]*model.SearchHit, 0, len(ranked))
+	for _, r := range ranked {
+		hits = append(hits, r.hit)
+	}
+	if len(hits) > searchMaxResults {
+		hits = hits[:searchMaxResults]
+	}
+
+	res.Total = len(hits)
+	start := (page - 1) * pageSize
+	if start < len(hits) {
+		end := min(start+pageSize, len(hits))
+		res.Hits = hits[start:end]
+	}
+	return res
+}
+
+// searchCandidates collects games that may match the query from the text
+// index and from the search keys of the query tokens. Each source returns
+// the most popular matches only.
+func (m *MongoDB) searchCandidates(ctx context.Context, raw string, q string) ([]*model.Game, error) {
+	coll := m.GameCollection
+	filters := []bson.M{
+		{"$text": bson.M{"$search": raw}},
+	}
+	for _, keys := range queryKeys(q) {
+		filters = append(filters, bson.M{"search_keys": bson.M{"$in": keys}})
+	}
+
+	seen := make(map[uint64]bool)
+	var candidates []*model.Game
+	for _, filter := range filters {
+		opts := options.Find().
+			SetProjection(searchProjection).
+			SetSort(bson.M{"total_rating_count": -1}).
+			SetLimit(searchCandidateLimit)
+		cursor, err := coll.Find(ctx, filter, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search games: %w", err)
+		}
+		var games []*model.Game
+		err = cursor.All(ctx, &games)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search games: %w", err)
+		}
+		for _, game := range games {
+			if seen[game.Id] {
+				continue
+			}
+			seen[game.Id] = true
+			candidates = append(candidates, game)
+		}
+	}
+	return candidates, nil
+}
+
+// searchKeys returns the keys a game with the given names is found by: the
+// tokens of the normalized names, their prefixes and, for tokens long
+// enough to allow a typo, the variants with one character deleted. A query
+// token within one edit of a name token shares a deletion variant with it,
+// so typos are found through the index too.
+func searchKeys(names []string) []string {
+	seen := make(map[string]bool)
+	keys := []string{}
+	add := func(key string) {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	for _, name := range names {
+		for _, token := range strings.Fields(normalizeName(name)) {
+			runes := []rune(token)
+			for i := minSearchKeyLength; i < len(runes); i++ {
+				add(string(runes[:i]))
+			}
+			for _, key := range tokenKeys(token) {
+				add(key)
+			}
+		}
+	}
+	return keys
+}
+
+// queryKeys returns the search keys to look up for each token of the
+// normalized query q. Single characters are skipped unless they are the
+// whole query.
+func queryKeys(q string) [][]string {
+	tokens := strings.Fields(q)
+	res := make([][]string, 0, len(tokens))
+	for _, token := range tokens {
+		if len([]rune(token)) < minSearchKeyLength && len(tokens) > 1 {
+			continue
+		}
+		res = append(res, tokenKeys(token))
+	}
+	return res
+}
+
+// tokenKeys returns token and its variants with one character deleted if
+// typoThreshold allows a typo in it.
+func tokenKeys(token string) []string {
+	keys := []string{token}
+	if typoThreshold(token) == 0 {
+		return keys
+	}
+	runes := []rune(token)
+	for i := range runes {
+		keys = append(keys, string(runes[:i])+string(runes[i+1:]))
+	}
+	return keys
+}
+
+func scoreGame(q string, game *model.Game) (float64, string) {
+	names := game.AllNames
+	if len(names) == 0 {
+		names = []string{game.Name}
+	}
+	best := 0.0
+	matched := ""
+	for _, name := range names {
+		score := scoreName(q, normalizeName(name))
+		if score > best {
+			best = score
+			matched = name
+		}
+	}
+	return best, matched
+}
+
+// scoreName scores how well name matches q. Each kind of match scores in
+// its own band of 10 points, see matchTier.
+func scoreName(q string, name string) float64 {
+	if name == "" {
+		return 0
+	}
+	if name == q {
+		return 100
+	}
+	if strings.HasPrefix(name, q) {
+		// shorter names are closer to an exact match
+		return 80 + 10*float64(len(q))/float64(len(name))
+	}
+
+	qTokens := strings.Fields(q)
+	nameTokens := strings.Fields(name)
+	exact, fuzzy := 0, 0
+	for _, qt := range qTokens {
+		switch {
+		case slices.Contains(nameTokens, qt):
+			exact++
+		case slices.ContainsFunc(nameTokens, func(nt string) bool {
+			return strings.HasPrefix(nt, qt) || editDistance(nt, qt) <= typoThreshold(qt)
+		}):
+			fuzzy++
+		}
+	}
+	if exact == len(qTokens) {
+		return 60 + 10*float64(len(qTokens))/float64(len(nameTokens))
+	}
+
+	if d := editDistance(name, q); d <= typoThreshold(q) {
+		return 50 - 5*float64(d)
+	}
+	if exact+fuzzy == len(qTokens) {
+		return 30 + 10*float64(exact)/float64(len(qTokens))
+	}
+	if exact+fuzzy > 0 {
+		return 20 * float64(exact+fuzzy) / float64(len(qTokens))
+	}
+	return 0
+}
+
+// matchTier returns the band of 10 points a score of scoreName is in.
+func matchTier(score float64) int {
+	return int(score / 10)
+}
+
+func popularityBoost(game *model.Game) float64 {
+	return 1 + math.Log10(1+float64(game.TotalRatingCount))/20 + math.Log10(1+float64(game.Hypes))/40
+}
+
+func typoThreshold(s string) int {
+	n := len([]rune(s))
+	switch {
+	case n <= 3:
+		return 0
+	case n <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func normalizeName(s string) string {
+	var b strings.Builder
+	space := true
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			space = false
+		} else if !space {
+			b.WriteRune(' ')
+			space = true
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// editDistance returns the optimal string alignment distance, i.e. the
+// Levenshtein distance where swapping two adjacent characters costs one edit.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,61 @@
+package db
+
+import (
+	"igdb-database/model"
+	"slices"
+	"testing"
+)
+
+func TestSearchKeysFindTypos(t *testing.T) {
+	keys := searchKeys([]string{"The Legend of Zelda: Breath of the Wild"})
+	tests := []struct {
+		query string
+		found bool
+	}{
+		{"zelda", true},
+		{"zel", true},
+		{"zleda", true},
+		{"xelda", true},
+		{"zeldaa", true},
+		{"legend zleda", true},
+		{"mario", false},
+		{"zlad", false},
+	}
+	for _, tt := range tests {
+		for _, tokenKeys := range queryKeys(normalizeName(tt.query)) {
+			found := slices.ContainsFunc(tokenKeys, func(key string) bool { return slices.Contains(keys, key) })
+			if found != tt.found {
+				t.Errorf("query %q token keys %v: found = %v, want %v", tt.query, tokenKeys, found, tt.found)
+			}
+		}
+	}
+}
+
+func TestScoreNameTiers(t *testing.T) {
+	q := normalizeName("zelda")
+	exact := scoreName(q, normalizeName("Zelda"))
+	prefix := scoreName(q, normalizeName("Zelda II"))
+	token := scoreName(q, normalizeName("The Legend of Zelda"))
+	typo := scoreName(normalizeName("zleda"), normalizeName("Zelda"))
+	if !(exact > prefix && prefix > token && token > typo && typo > 0) {
+		t.Errorf("scores exact %v, prefix %v, token %v, typo %v are not in tier order", exact, prefix, token, typo)
+	}
+}
+
+func TestRankSearchHitsKeepsTiers(t *testing.T) {
+	candidates := []*model.Game{
+		{Id: 1, Name: "Zelda II", TotalRatingCount: 100000, Hypes: 100000},
+		{Id: 2, Name: "Zelda"},
+		{Id: 3, Name: "Zelda III", TotalRatingCount: 1000},
+		{Id: 4, Name: "Zelda IV", TotalRatingCount: 10},
+	}
+	res := rankSearchHits(normalizeName("zelda"), candidates, 1, 10)
+	ids := []uint64{}
+	for _, hit := range res.Hits {
+		ids = append(ids, hit.Id)
+	}
+	// the exact match first, then the prefix matches by popularity
+	if want := []uint64{2, 1, 3, 4}; !slices.Equal(ids, want) {
+		t.Errorf("ranked ids = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"igdb-database/model"
+	"iter"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrNotFound is returned by every Store when a requested item or game does
+// not exist. It is the mongo error so existing errors.Is checks keep working
+// with any backend.
+var ErrNotFound = mongo.ErrNoDocuments
+
+// Store is the storage used by the collector, the aggregation and the
+// webhook server.
+//
+// Items of an endpoint are returned as raw BSON documents, use the generic
+// helpers GetItemById, GetItemsByIds, GetItemsAfter and IterateItems to
+// decode them.
+type Store interface {
+	Ping(ctx context.Context) error
+
+	SaveItem(ctx context.Context, e endpoint.Name, item IdGetter) error
+	SaveItems(ctx context.Context, e endpoint.Name, items []IdGetter) error
+	GetItemById(ctx context.Context, e endpoint.Name, id uint64) (bson.Raw, error)
+	GetItemsByIds(ctx context.Context, e endpoint.Name, ids []uint64) ([]bson.Raw, error)
+	GetItemsAfter(ctx context.Context, e endpoint.Name, afterId uint64, limit int64) ([]bson.Raw, error)
+	GetLatestUpdatedAt(ctx context.Context, e endpoint.Name) (*timestamppb.Timestamp, error)
+	CountDocuments(ctx context.Context, e endpoint.Name) (int64, error)
+	EstimatedDocumentCount(ctx context.Context, e endpoint.Name) (int64, error)
+	GetExternalGames(ctx context.Context, sourceId uint64, uids []string) ([]bson.Raw, error)
+	RemoveItem(ctx context.Context, e endpoint.Name, id uint64) error
+
+	SaveGame(ctx context.Context, game *model.Game) error
+	SaveGames(ctx context.Context, games []*model.Game) error
+	GetGameById(ctx context.Context, id uint64) (*model.Game, error)
+	GetGameBySlug(ctx context.Context, slug string) (*model.Game, error)
+	GetGamesByIds(ctx context.Context, ids []uint64) ([]*model.Game, error)
+	IsGamesAggregated(ctx context.Context, ids []uint64) (map[uint64]bool, error)
+	CountGames(ctx context.Context) (int64, error)
+	GetGameSummaries(ctx context.Context, ids []uint64) ([]*model.GameSummary, error)
+	GetFamilyGames(ctx context.Context, ids []uint64, parentIds []uint64) ([]*model.Game, error)
+	SearchGames(ctx context.Context, query string, page int, pageSize int) (*model.SearchResult, error)
+	PatchEmbeddedItem(ctx context.Context, e endpoint.Name, item IdGetter) (int64, error)
+	PatchRelatedGameSummary(ctx context.Context, summary *model.GameSummary) (int64, error)
+	RemoveFromGames(ctx context.Context, e endpoint.Name, id uint64) error
+
+	SaveViewDocuments(ctx context.Context, view string, docs []bson.Raw) error
+	GetViewDocument(ctx context.Context, view string, id uint64) (bson.Raw, error)
+	GetViewDocuments(ctx context.Context, view string, ids []uint64) ([]bson.Raw, error)
+	ViewDocumentIds(ctx context.Context, v View, e endpoint.Name, ids []uint64) ([]uint64, error)
+	RemoveViewDocument(ctx context.Context, view string, id uint64) error
+
+	GetSyncState(ctx context.Context, e endpoint.Name) (*model.SyncState, error)
+	GetSyncStates(ctx context.Context) ([]*model.SyncState, error)
+	StartSyncState(ctx context.Context, state *model.SyncState) error
+	MarkPageCompleted(ctx context.Context, e endpoint.Name, offset uint64) error
+	MarkPageFailed(ctx context.Context, e endpoint.Name, offset uint64, pageErr error) error
+	FinishSyncState(ctx context.Context, e endpoint.Name) error
+
+	SaveRun(ctx context.Context, run *model.Run) error
+	GetRuns(ctx context.Context) ([]*model.Run, error)
+
+	EnqueueWebhookEvent(ctx context.Context, event *model.WebhookEvent) error
+	ClaimWebhookEvent(ctx context.Context, lockFor time.Duration) (*model.WebhookEvent, error)
+	CompleteWebhookEvent(ctx context.Context, event *model.WebhookEvent) error
+	RetryWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error, next time.Time) error
+	DeadLetterWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error) error
+	GetDeadLetters(ctx context.Context, after bson.ObjectID, limit int64) ([]*model.WebhookEvent, error)
+	ReplayDeadLetters(ctx context.Context, ids []bson.ObjectID) (int, error)
+	CountWebhookEvents(ctx context.Context) (int64, error)
+	CountDeadLetters(ctx context.Context) (int64, error)
+}
+
+func GetItemById[T any](ctx context.Context, s Store, e endpoint.Name, id uint64) (*T, error) {
+	raw, err := s.GetItemById(ctx, e, id)
+	if err != nil {
+		return nil, err
+	}
+	var item *T
+	err = decodeDocument(raw, &item)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", string(e), err)
+	}
+	return item, nil
+}
+
+func GetItemsByIds[T any](ctx context.Context, s Store, e endpoint.Name, ids []uint64) ([]*T, error) {
+	raws, err := s.GetItemsByIds(ctx, e, ids)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocuments[T](e, raws)
+}
+
+func GetItemsAfter[T any](ctx context.Context, s Store, e endpoint.Name, afterId uint64, limit int64) ([]*T, error) {
+	raws, err := s.GetItemsAfter(ctx, e, afterId, limit)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocuments[T](e, raws)
+}
+
+// IterateItems walks all items of e in id order, batchSize items at a time.
+// Each batch continues after the last id of the previous one, so the walk
+// stays fast on large collections and items inserted meanwhile are neither
+// skipped nor returned twice.
+func IterateItems[T any](ctx context.Context, s Store, e endpoint.Name, batchSize int64) iter.Seq2[[]*T, error] {
+	return func(yield func([]*T, error) bool) {
+		afterId := uint64(0)
+		for {
+			items, err := GetItemsAfter[T](ctx, s, e, afterId, batchSize)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if len(items) == 0 {
+				return
+			}
+			if !yield(items, nil) {
+				return
+			}
+			if int64(len(items)) < batchSize {
+				return
+			}
+			afterId = any(items[len(items)-1]).(IdGetter).GetId()
+		}
+	}
+}
+
+func SaveItem[T any](ctx context.Context, s Store, e endpoint.Name, item *T) error {
+	return s.SaveItem(ctx, e, any(item).(IdGetter))
+}
+
+func SaveItems[T any](ctx context.Context, s Store, e endpoint.Name, items []*T) error {
+	if len(items) == 0 {
+		return nil
+	}
+	getters := make([]IdGetter, 0, len(items))
+	for _, item := range items {
+		getters = append(getters, any(item).(IdGetter))
+	}
+	return s.SaveItems(ctx, e, getters)
+}
+
+func decodeDocuments[T any](e endpoint.Name, raws []bson.Raw) ([]*T, error) {
+	items := make([]*T, 0, len(raws))
+	for _, raw := range raws {
+		var item *T
+		err := decodeDocument(raw, &item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", string(e), err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// decodeDocument decodes raw the same way the mongo client does, i.e. with
+// the json struct tags of the protobuf types.
+func decodeDocument(raw bson.Raw, v any) error {
+	dec := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	dec.UseJSONStructTags()
+	return dec.Decode(v)
+}
+
+// encodeDocument is the counterpart of decodeDocument.
+func encodeDocument(v any) (bson.Raw, error) {
+	buf := &bytes.Buffer{}
+	enc := bson.NewEncoder(bson.NewDocumentWriter(buf))
+	enc.UseJSONStructTags()
+	err := enc.Encode(v)
+	if err != nil {
+		return nil, err
+	}
+	return bson.Raw(buf.Bytes()), nil
+}
+
+var (
+	_ Store = (*MongoDB)(nil)
+	_ Store = (*MemoryStore)(nil)
+)
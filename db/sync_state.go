@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/model"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// GetSyncState returns the fetch progress of e, or nil if e was never fetched.
+func (m *MongoDB) GetSyncState(ctx context.Context, e endpoint.Name) (*model.SyncState, error) {
+	var state model.SyncState
+	err := m.SyncStateCollection.FindOne(ctx, bson.M{"endpoint": e}).Decode(&state)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sync state %s: %w", string(e), err)
+	}
+	return &state, nil
+}
+
+// GetSyncStates returns the fetch progress of all endpoints.
+func (m *MongoDB) GetSyncStates(ctx context.Context) ([]*model.SyncState, error) {
+	cursor, err := m.SyncStateCollection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"endpoint": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync states: %w", err)
+	}
+	var states []*model.SyncState
+	err = cursor.All(ctx, &states)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sync states: %w", err)
+	}
+	return states, nil
+}
+
+// StartSyncState replaces any previous progress of the endpoint with state.
+func (m *MongoDB) StartSyncState(ctx context.Context, state *model.SyncState) error {
+	opts := options.Replace().SetUpsert(true)
+	_, err := m.SyncStateCollection.ReplaceOne(ctx, bson.M{"endpoint": state.Endpoint}, state, opts)
+	if err != nil {
+		return fmt.Errorf("failed to start sync state %s: %w", string(state.Endpoint), err)
+	}
+	return nil
+}
+
+func (m *MongoDB) MarkPageCompleted(ctx context.Context, e endpoint.Name, offset uint64) error {
+	update := bson.M{
+		"$addToSet": bson.M{"completed_offsets": offset},
+		"$pull":     bson.M{"failed_offsets": offset},
+	}
+	_, err := m.SyncStateCollection.UpdateOne(ctx, bson.M{"endpoint": e}, update)
+	if err != nil {
+		return fmt.Errorf("failed to mark page %d of %s completed: %w", offset, string(e), err)
+	}
+	return nil
+}
+
+func (m *MongoDB) MarkPageFailed(ctx context.Context, e endpoint.Name, offset uint64, pageErr error) error {
+	update := bson.M{
+		"$addToSet": bson.M{"failed_offsets": offset},
+		"$set":      bson.M{"last_error": pageErr.Error()},
+	}
+	_, err := m.SyncStateCollection.UpdateOne(ctx, bson.M{"endpoint": e}, update)
+	if err != nil {
+		return fmt.Errorf("failed to mark page %d of %s failed: %w", offset, string(e), err)
+	}
+	return nil
+}
+
+func (m *MongoDB) FinishSyncState(ctx context.Context, e endpoint.Name) error {
+	update := bson.M{"$set": bson.M{"finished_at": time.Now()}}
+	_, err := m.SyncStateCollection.UpdateOne(ctx, bson.M{"endpoint": e}, update)
+	if err != nil {
+		return fmt.Errorf("failed to finish sync state %s: %w", string(e), err)
+	}
+	return nil
+}
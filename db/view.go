@@ -0,0 +1,384 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/model"
+	"slices"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+	pb "github.com/bestnite/go-igdb/proto"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// View is an aggregated view of the items of one endpoint besides
+// game_details, e.g. a company with its logo and the games it developed.
+// Documents are the item with its references expanded like in aggregated
+// games and its games replaced by game summaries.
+type View interface {
+	// Name is the name of the collection the view is stored in.
+	Name() string
+	// Path is the path the view is served on below /v1.
+	Path() string
+	EndpointName() endpoint.Name
+	// Paths returns the paths of the ids of items of e in the documents.
+	Paths(e endpoint.Name) []string
+	// Build builds the documents of the items with the given ids. Missing
+	// items are fetched from IGDB unless client is nil.
+	Build(ctx context.Context, s Store, client *igdb.Client, ids []uint64) ([]bson.Raw, error)
+	// aggregate builds and saves the documents of all stored items.
+	aggregate(ctx context.Context, s Store, client *igdb.Client) (int, error)
+}
+
+// Views declares the views aggregated besides game_details.
+var Views = []View{
+	newView("company_details", "companies", endpoint.EPCompanies,
+		func(c *igdb.Client) endpoint.EntityEndpoint[pb.Company] { return c.Companies },
+		nil,
+		viewGames("developed", (*pb.Company).GetDeveloped),
+		viewGames("published", (*pb.Company).GetPublished),
+	),
+	newView("platform_details", "platforms", endpoint.EPPlatforms,
+		func(c *igdb.Client) endpoint.EntityEndpoint[pb.Platform] { return c.Platforms },
+		[]expansion{
+			refsExpansion(endpoint.EPPlatforms, "versions", endpoint.EPPlatformVersions, (*pb.Platform).GetVersions, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlatformVersion] { return c.PlatformVersions }, func(p *pb.Platform, v []*pb.PlatformVersion) { p.Versions = v }),
+			refExpansion(endpoint.EPPlatformVersions, "platform_logo", endpoint.EPPlatformLogos, (*pb.PlatformVersion).GetPlatformLogo, func(c *igdb.Client) endpoint.EntityEndpoint[pb.PlatformLogo] { return c.PlatformLogos }, func(p *pb.PlatformVersion, v *pb.PlatformLogo) { p.PlatformLogo = v }),
+		},
+	),
+	newView("franchise_details", "franchises", endpoint.EPFranchises,
+		func(c *igdb.Client) endpoint.EntityEndpoint[pb.Franchise] { return c.Franchises },
+		nil,
+		viewGames("games", (*pb.Franchise).GetGames),
+	),
+	newView("collection_details", "collections", endpoint.EPCollections,
+		func(c *igdb.Client) endpoint.EntityEndpoint[pb.Collection] { return c.Collections },
+		nil,
+		viewGames("games", (*pb.Collection).GetGames),
+	),
+}
+
+// AggregateView builds the documents of all stored items of v, replacing
+// the saved ones, and returns how many were saved.
+func AggregateView(ctx context.Context, s Store, client *igdb.Client, v View) (int, error) {
+	return v.aggregate(ctx, s, client)
+}
+
+// gameList replaces a list of games referenced by the item of a view by
+// game summaries.
+type gameList[T any] struct {
+	field string
+	get   func(item *T) []*pb.Game
+}
+
+func viewGames[T any](field string, get func(item *T) []*pb.Game) gameList[T] {
+	return gameList[T]{field: field, get: get}
+}
+
+// itemView is a view of items of type T.
+type itemView[T any] struct {
+	name  string
+	path  string
+	root  endpoint.Name
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[T]
+	// expansions are applied before gameExpansions, so items are expanded
+	// the same way they are in aggregated games.
+	expansions []expansion
+	games      []gameList[T]
+}
+
+func newView[T any](
+	name string,
+	path string,
+	root endpoint.Name,
+	fetch func(client *igdb.Client) endpoint.EntityEndpoint[T],
+	expansions []expansion,
+	games ...gameList[T],
+) View {
+	return &itemView[T]{
+		name:       name,
+		path:       path,
+		root:       root,
+		fetch:      fetch,
+		expansions: append(slices.Clone(expansions), gameExpansions...),
+		games:      games,
+	}
+}
+
+func (v *itemView[T]) Name() string {
+	return v.name
+}
+
+func (v *itemView[T]) Path() string {
+	return v.path
+}
+
+func (v *itemView[T]) EndpointName() endpoint.Name {
+	return v.root
+}
+
+func (v *itemView[T]) Paths(e endpoint.Name) []string {
+	paths := []string{}
+	if e == endpoint.EPGames {
+		for _, g := range v.games {
+			paths = append(paths, g.field+".id")
+		}
+		return paths
+	}
+	for _, p := range nestedPaths(v.root, e, v.expansions, expandDepth()) {
+		if p.path == "" {
+			paths = append(paths, "id")
+		} else {
+			paths = append(paths, p.path+".id")
+		}
+	}
+	return paths
+}
+
+func (v *itemView[T]) Build(ctx context.Context, s Store, client *igdb.Client, ids []uint64) ([]bson.Raw, error) {
+	var fetcher endpoint.EntityEndpoint[T]
+	if client != nil {
+		fetcher = v.fetch(client)
+	}
+	found, err := resolveItems(ctx, s, v.root, fetcher, ids)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*T, 0, len(found))
+	for _, id := range uniqueIds(ids) {
+		if item, ok := found[id]; ok {
+			items = append(items, item)
+		}
+	}
+	return v.build(ctx, s, client, items)
+}
+
+func (v *itemView[T]) build(ctx context.Context, s Store, client *igdb.Client, items []*T) ([]bson.Raw, error) {
+	roots := make([]any, 0, len(items))
+	for _, item := range items {
+		roots = append(roots, item)
+	}
+	_, err := expandItems(ctx, s, client, v.expansions, map[endpoint.Name][]any{v.root: roots}, expandDepth())
+	if err != nil {
+		return nil, err
+	}
+
+	gameIds := []uint64{}
+	for _, item := range items {
+		for _, g := range v.games {
+			for _, game := range g.get(item) {
+				gameIds = append(gameIds, game.Id)
+			}
+		}
+	}
+	summaries := make(map[uint64]*model.GameSummary, len(gameIds))
+	gameIds = uniqueIds(gameIds)
+	for i := 0; i < len(gameIds); i += maxIgdbIds {
+		res, err := s.GetGameSummaries(ctx, gameIds[i:min(i+maxIgdbIds, len(gameIds))])
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range res {
+			summaries[summary.Id] = summary
+		}
+	}
+
+	docs := make([]bson.Raw, 0, len(items))
+	for _, item := range items {
+		raw, err := encodeDocument(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", v.name, err)
+		}
+		elems, err := raw.Elements()
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", v.name, err)
+		}
+		doc := make(bson.D, 0, len(elems)+len(v.games))
+		for _, elem := range elems {
+			doc = append(doc, bson.E{Key: elem.Key(), Value: elem.Value()})
+		}
+		for _, g := range v.games {
+			games := g.get(item)
+			if len(games) == 0 {
+				continue
+			}
+			// games that are not aggregated yet keep their id, so they
+			// are filled in once they are aggregated
+			list := make([]*model.GameSummary, 0, len(games))
+			for _, game := range games {
+				if summary, ok := summaries[game.Id]; ok {
+					list = append(list, summary)
+				} else {
+					list = append(list, &model.GameSummary{Id: game.Id})
+				}
+			}
+			doc = setElement(doc, g.field, list)
+		}
+		raw, err = encodeDocument(doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode %s: %w", v.name, err)
+		}
+		docs = append(docs, raw)
+	}
+	return docs, nil
+}
+
+func (v *itemView[T]) aggregate(ctx context.Context, s Store, client *igdb.Client) (int, error) {
+	saved := 0
+	for items, err := range IterateItems[T](ctx, s, v.root, maxIgdbIds) {
+		if err != nil {
+			return saved, fmt.Errorf("failed to get %s: %w", string(v.root), err)
+		}
+		// the batch in flight is finished even if ctx is cancelled
+		batchCtx := context.WithoutCancel(ctx)
+		docs, err := v.build(batchCtx, s, client, items)
+		if err != nil {
+			return saved, fmt.Errorf("failed to build %s: %w", v.name, err)
+		}
+		err = s.SaveViewDocuments(batchCtx, v.name, docs)
+		if err != nil {
+			return saved, fmt.Errorf("failed to save %s: %w", v.name, err)
+		}
+		saved += len(docs)
+		if err := ctx.Err(); err != nil {
+			return saved, fmt.Errorf("aggregation of %s interrupted: %w", v.name, err)
+		}
+	}
+	return saved, nil
+}
+
+// setElement sets key in doc, keeping its position if it is set already.
+func setElement(doc bson.D, key string, value any) bson.D {
+	for i := range doc {
+		if doc[i].Key == key {
+			doc[i].Value = value
+			return doc
+		}
+	}
+	return append(doc, bson.E{Key: key, Value: value})
+}
+
+// documentId returns the IGDB id of a view document.
+func documentId(doc bson.Raw) (uint64, error) {
+	value, err := doc.LookupErr("id")
+	if err != nil {
+		return 0, fmt.Errorf("document has no id: %w", err)
+	}
+	id, ok := value.AsInt64OK()
+	if !ok || id <= 0 {
+		return 0, fmt.Errorf("document has an invalid id %s", value.String())
+	}
+	return uint64(id), nil
+}
+
+func (m *MongoDB) viewCollection(view string) (*mongo.Collection, error) {
+	coll := m.ViewCollections[view]
+	if coll == nil {
+		return nil, fmt.Errorf("view %s not found", view)
+	}
+	return coll, nil
+}
+
+func (m *MongoDB) SaveViewDocuments(ctx context.Context, view string, docs []bson.Raw) error {
+	coll, err := m.viewCollection(view)
+	if err != nil {
+		return err
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, 0, len(docs))
+	for _, doc := range docs {
+		id, err := documentId(doc)
+		if err != nil {
+			return err
+		}
+		models = append(models, mongo.NewReplaceOneModel().SetFilter(bson.M{"id": id}).SetReplacement(doc).SetUpsert(true))
+	}
+	_, err = coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return fmt.Errorf("failed to save %s: %w", view, err)
+	}
+	return nil
+}
+
+func (m *MongoDB) GetViewDocument(ctx context.Context, view string, id uint64) (bson.Raw, error) {
+	coll, err := m.viewCollection(view)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 0})
+	doc, err := coll.FindOne(ctx, bson.M{"id": id}, opts).Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", view, err)
+	}
+	return doc, nil
+}
+
+func (m *MongoDB) GetViewDocuments(ctx context.Context, view string, ids []uint64) ([]bson.Raw, error) {
+	coll, err := m.viewCollection(view)
+	if err != nil {
+		return nil, err
+	}
+	opts := options.Find().SetProjection(bson.M{"_id": 0})
+	cursor, err := coll.Find(ctx, bson.M{"id": bson.M{"$in": ids}}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", view, err)
+	}
+	var docs []bson.Raw
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", view, err)
+	}
+	return docs, nil
+}
+
+// ViewDocumentIds returns the ids of the documents of v that contain one of
+// the items of e with the given ids.
+func (m *MongoDB) ViewDocumentIds(ctx context.Context, v View, e endpoint.Name, ids []uint64) ([]uint64, error) {
+	coll, err := m.viewCollection(v.Name())
+	if err != nil {
+		return nil, err
+	}
+	paths := v.Paths(e)
+	if len(paths) == 0 || len(ids) == 0 {
+		return nil, nil
+	}
+	filter := bson.A{}
+	for _, path := range paths {
+		filter = append(filter, bson.M{path: bson.M{"$in": ids}})
+	}
+	opts := options.Find().SetProjection(bson.M{"id": 1})
+	cursor, err := coll.Find(ctx, bson.M{"$or": filter}, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", v.Name(), err)
+	}
+	var docs []struct {
+		Id uint64 `json:"id"`
+	}
+	err = cursor.All(ctx, &docs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s: %w", v.Name(), err)
+	}
+	res := make([]uint64, 0, len(docs))
+	for _, doc := range docs {
+		res = append(res, doc.Id)
+	}
+	return res, nil
+}
+
+// RemoveViewDocument deletes the document of the item with the given id
+// from the view stored in the collection view.
+func (m *MongoDB) RemoveViewDocument(ctx context.Context, view string, id uint64) error {
+	coll, err := m.viewCollection(view)
+	if err != nil {
+		return err
+	}
+	_, err = coll.DeleteOne(ctx, bson.M{"id": id})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s %d: %w", view, id, err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"igdb-database/model"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// ErrWebhookLockLost is returned when a worker finishes an event whose lock
+// expired and was claimed by another worker.
+var ErrWebhookLockLost = errors.New("webhook event lock lost")
+
+// EnqueueWebhookEvent stores a received webhook call in the queue. The queue
+// holds one event per entity: a later call replaces the method of the queued
+// event, so bursts of calls for one entity are processed once and in order.
+// An event that is being processed is queued again when its worker finishes.
+func (m *MongoDB) EnqueueWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	now := time.Now()
+	filter := bson.M{
+		"endpoint":  event.Endpoint,
+		"entity_id": event.EntityId,
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"method":          event.Method,
+			"request_id":      event.RequestId,
+			"next_attempt_at": now,
+		},
+		"$inc": bson.M{"version": 1},
+		"$setOnInsert": bson.M{
+			"status":       model.WebhookEventPending,
+			"attempts":     0,
+			"locked_until": now,
+			"created_at":   now,
+		},
+	}
+	opts := options.UpdateOne().SetUpsert(true)
+	_, err := m.WebhookEventCollection.UpdateOne(ctx, filter, update, opts)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook event: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookEvent locks the next due event for lockFor and returns it, or
+// nil if no event is due. Events whose lock expired, e.g. because the worker
+// died, are claimed again and the lost attempt is counted.
+func (m *MongoDB) ClaimWebhookEvent(ctx context.Context, lockFor time.Duration) (*model.WebhookEvent, error) {
+	now := time.Now()
+	filter := bson.M{"$or": bson.A{
+		bson.M{"status": model.WebhookEventPending, "next_attempt_at": bson.M{"$lte": now}},
+		bson.M{"status": model.WebhookEventProcessing, "locked_until": bson.M{"$lte": now}},
+	}}
+	update := bson.A{bson.M{"$set": bson.M{
+		"attempts": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$status", model.WebhookEventProcessing}},
+			bson.M{"$add": bson.A{"$attempts", 1}},
+			"$attempts",
+		}},
+		"status":       model.WebhookEventProcessing,
+		"locked_until": now.Add(lockFor),
+	}}}
+	opts := options.FindOneAndUpdate().
+		SetSort(bson.M{"next_attempt_at": 1}).
+		SetReturnDocument(options.After)
+
+	var event model.WebhookEvent
+	err := m.WebhookEventCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to claim webhook event: %w", err)
+	}
+	return &event, nil
+}
+
+// ownedBy matches event as long as the worker that claimed it holds the lock.
+func ownedBy(event *model.WebhookEvent) bson.M {
+	return bson.M{
+		"_id":          event.MId,
+		"status":       model.WebhookEventProcessing,
+		"locked_until": event.LockedUntil,
+	}
+}
+
+// CompleteWebhookEvent removes a processed event from the queue. If another
+// call was queued for the entity meanwhile, the event is queued again as a
+// new one instead.
+func (m *MongoDB) CompleteWebhookEvent(ctx context.Context, event *model.WebhookEvent) error {
+	filter := ownedBy(event)
+	filter["version"] = event.Version
+	res, err := m.WebhookEventCollection.DeleteOne(ctx, filter)
+	if err != nil {
+		return fmt.Errorf("failed to complete webhook event: %w", err)
+	}
+	if res.DeletedCount > 0 {
+		return nil
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"status":          model.WebhookEventPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		},
+		"$unset": bson.M{"last_error": ""},
+	}
+	updateRes, err := m.WebhookEventCollection.UpdateOne(ctx, ownedBy(event), update)
+	if err != nil {
+		return fmt.Errorf("failed to complete webhook event: %w", err)
+	}
+	if updateRes.MatchedCount == 0 {
+		return ErrWebhookLockLost
+	}
+	return nil
+}
+
+// RetryWebhookEvent puts a failed event back in the queue to be processed
+// again at next.
+func (m *MongoDB) RetryWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error, next time.Time) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":          model.WebhookEventPending,
+			"last_error":      eventErr.Error(),
+			"next_attempt_at": next,
+		},
+		"$inc": bson.M{"attempts": 1},
+	}
+	res, err := m.WebhookEventCollection.UpdateOne(ctx, ownedBy(event), update)
+	if err != nil {
+		return fmt.Errorf("failed to retry webhook event: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrWebhookLockLost
+	}
+	return nil
+}
+
+// DeadLetterWebhookEvent moves an event that keeps failing from the queue to
+// the dead-letter store. If another call was queued for the entity meanwhile,
+// that call stays queued.
+func (m *MongoDB) DeadLetterWebhookEvent(ctx context.Context, event *model.WebhookEvent, eventErr error) error {
+	err := m.CompleteWebhookEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	// a requeued event keeps its id and may fail again
+	event.MId = bson.NewObjectID()
+	event.Attempts++
+	event.LastError = eventErr.Error()
+	event.FailedAt = &now
+	_, err = m.DeadLetterCollection.InsertOne(ctx, event)
+	if err != nil {
+		return fmt.Errorf("failed to dead letter webhook event: %w", err)
+	}
+	return nil
+}
+
+// GetDeadLetters returns up to limit dead letters, the newest first. Dead
+// letters get a new id when they are stored, so pages are read by id: pass
+// the id of the last event of the previous page as after, or the zero id
+// for the first page.
+func (m *MongoDB) GetDeadLetters(ctx context.Context, after bson.ObjectID, limit int64) ([]*model.WebhookEvent, error) {
+	filter := bson.M{}
+	if !after.IsZero() {
+		filter["_id"] = bson.M{"$lt": after}
+	}
+	opts := options.Find().SetSort(bson.M{"_id": -1}).SetLimit(limit)
+	cursor, err := m.DeadLetterCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	events := []*model.WebhookEvent{}
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	return events, nil
+}
+
+// ReplayDeadLetters moves the given dead-lettered events, or all of them if
+// ids is empty, back to the queue. It returns the number of replayed events.
+func (m *MongoDB) ReplayDeadLetters(ctx context.Context, ids []bson.ObjectID) (int, error) {
+	filter := bson.M{}
+	if len(ids) > 0 {
+		filter = bson.M{"_id": bson.M{"$in": ids}}
+	}
+	cursor, err := m.DeadLetterCollection.Find(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	var events []*model.WebhookEvent
+	err = cursor.All(ctx, &events)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+
+	replayed := 0
+	for _, event := range events {
+		err = m.EnqueueWebhookEvent(ctx, event)
+		if err != nil {
+			return replayed, err
+		}
+		_, err = m.DeadLetterCollection.DeleteOne(ctx, bson.M{"_id": event.MId})
+		if err != nil {
+			return replayed, fmt.Errorf("failed to remove dead letter: %w", err)
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (m *MongoDB) CountWebhookEvents(ctx context.Context) (int64, error) {
+	count, err := m.WebhookEventCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count webhook events: %w", err)
+	}
+	return count, nil
+}
+
+func (m *MongoDB) CountDeadLetters(ctx context.Context) (int64, error) {
+	count, err := m.DeadLetterCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count dead letters: %w", err)
+	}
+	return count, nil
+}
@@ -0,0 +1,82 @@
+package fakeigdb
+
+import (
+	"errors"
+
+	"github.com/bestnite/go-igdb"
+)
+
+// RegisterAll registers every entity endpoint of client.
+func RegisterAll(s *Server, client *igdb.Client) error {
+	return errors.Join(
+		Register(s, client.AgeRatingCategories),
+		Register(s, client.AgeRatingContentDescriptions),
+		Register(s, client.AgeRatingContentDescriptionsV2),
+		Register(s, client.AgeRatingOrganizations),
+		Register(s, client.AgeRatings),
+		Register(s, client.AlternativeNames),
+		Register(s, client.Artworks),
+		Register(s, client.CharacterGenders),
+		Register(s, client.CharacterMugShots),
+		Register(s, client.CharacterSpecies),
+		Register(s, client.Characters),
+		Register(s, client.CollectionMembershipTypes),
+		Register(s, client.CollectionMemberships),
+		Register(s, client.CollectionRelationTypes),
+		Register(s, client.CollectionRelations),
+		Register(s, client.CollectionTypes),
+		Register(s, client.Collections),
+		Register(s, client.Companies),
+		Register(s, client.CompanyLogos),
+		Register(s, client.CompanyStatuses),
+		Register(s, client.CompanyWebsites),
+		Register(s, client.Covers),
+		Register(s, client.DateFormats),
+		Register(s, client.EventLogos),
+		Register(s, client.EventNetworks),
+		Register(s, client.Events),
+		Register(s, client.ExternalGameSources),
+		Register(s, client.ExternalGames),
+		Register(s, client.Franchises),
+		Register(s, client.GameEngineLogos),
+		Register(s, client.GameEngines),
+		Register(s, client.GameLocalizations),
+		Register(s, client.GameModes),
+		Register(s, client.GameReleaseFormats),
+		Register(s, client.GameStatuses),
+		Register(s, client.GameTimeToBeats),
+		Register(s, client.GameTypes),
+		Register(s, client.GameVersionFeatureValues),
+		Register(s, client.GameVersionFeatures),
+		Register(s, client.GameVersions),
+		Register(s, client.GameVideos),
+		Register(s, client.Games),
+		Register(s, client.Genres),
+		Register(s, client.InvolvedCompanies),
+		Register(s, client.Keywords),
+		Register(s, client.LanguageSupportTypes),
+		Register(s, client.LanguageSupports),
+		Register(s, client.Languages),
+		Register(s, client.MultiplayerModes),
+		Register(s, client.NetworkTypes),
+		Register(s, client.PlatformFamilies),
+		Register(s, client.PlatformLogos),
+		Register(s, client.PlatformTypes),
+		Register(s, client.PlatformVersionCompanies),
+		Register(s, client.PlatformVersionReleaseDates),
+		Register(s, client.PlatformVersions),
+		Register(s, client.PlatformWebsites),
+		Register(s, client.Platforms),
+		Register(s, client.PlayerPerspectives),
+		Register(s, client.PopularityPrimitives),
+		Register(s, client.PopularityTypes),
+		Register(s, client.Regions),
+		Register(s, client.ReleaseDateRegions),
+		Register(s, client.ReleaseDateStatuses),
+		Register(s, client.ReleaseDates),
+		Register(s, client.Screenshots),
+		Register(s, client.Themes),
+		Register(s, client.WebsiteTypes),
+		Register(s, client.Websites),
+	)
+}
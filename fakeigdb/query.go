@@ -0,0 +1,299 @@
+package fakeigdb
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	defaultLimit = 10
+	maxLimit     = 500
+)
+
+// query is the subset of Apicalypse understood by the fake server:
+// fields, exclude, where (conditions joined with &), sort, limit and offset.
+type query struct {
+	fields   []string
+	exclude  []string
+	where    []condition
+	sort     string
+	sortDesc bool
+	limit    int
+	offset   int
+}
+
+type condition struct {
+	field  string
+	op     string
+	values []any
+}
+
+var conditionRegexp = regexp.MustCompile(`^([a-z0-9_.]+)\s*(!=|>=|<=|=|>|<)\s*(.+)$`)
+
+func parseQuery(s string) (*query, error) {
+	q := &query{limit: defaultLimit}
+	for _, stmt := range strings.Split(s, ";") {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		keyword, rest, _ := strings.Cut(stmt, " ")
+		rest = strings.TrimSpace(rest)
+		switch keyword {
+		case "fields", "f":
+			q.fields = splitList(rest)
+		case "exclude", "x":
+			q.exclude = splitList(rest)
+		case "where", "w":
+			where, err := parseWhere(rest)
+			if err != nil {
+				return nil, err
+			}
+			q.where = where
+		case "sort", "s":
+			field, order, _ := strings.Cut(rest, " ")
+			q.sort = field
+			q.sortDesc = strings.TrimSpace(order) == "desc"
+		case "limit", "l":
+			limit, err := strconv.Atoi(rest)
+			if err != nil || limit < 1 || limit > maxLimit {
+				return nil, fmt.Errorf("invalid limit %q", rest)
+			}
+			q.limit = limit
+		case "offset", "o":
+			offset, err := strconv.Atoi(rest)
+			if err != nil || offset < 0 {
+				return nil, fmt.Errorf("invalid offset %q", rest)
+			}
+			q.offset = offset
+		case "search":
+			return nil, fmt.Errorf("search is not supported")
+		default:
+			return nil, fmt.Errorf("unknown statement %q", stmt)
+		}
+	}
+	return q, nil
+}
+
+func splitList(s string) []string {
+	items := strings.Split(s, ",")
+	for i := range items {
+		items[i] = strings.TrimSpace(items[i])
+	}
+	return items
+}
+
+func parseWhere(s string) ([]condition, error) {
+	if strings.Contains(s, "|") {
+		return nil, fmt.Errorf("| is not supported in where")
+	}
+	conds := []condition{}
+	for _, part := range strings.Split(s, "&") {
+		m := conditionRegexp.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("invalid condition %q", part)
+		}
+		values, err := parseValues(strings.TrimSpace(m[3]))
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, condition{field: m[1], op: m[2], values: values})
+	}
+	return conds, nil
+}
+
+// parseValues parses a single value or a (a,b,c) list. Numbers are returned
+// as float64, null as nil.
+func parseValues(s string) ([]any, error) {
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		values := []any{}
+		for _, item := range splitList(s[1 : len(s)-1]) {
+			v, err := parseValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	v, err := parseValue(s)
+	if err != nil {
+		return nil, err
+	}
+	return []any{v}, nil
+}
+
+func parseValue(s string) (any, error) {
+	switch {
+	case s == "null":
+		return nil, nil
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) && len(s) >= 2:
+		return s[1 : len(s)-1], nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q", s)
+	}
+	return f, nil
+}
+
+// matches reports whether msg satisfies all conditions of q.
+func (q *query) matches(msg protoreflect.Message) bool {
+	for _, c := range q.where {
+		if !c.matches(fieldValues(msg, c.field)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c condition) matches(values []any) bool {
+	if len(c.values) == 1 && c.values[0] == nil {
+		if c.op == "=" {
+			return len(values) == 0
+		}
+		return len(values) > 0
+	}
+	if c.op == "!=" {
+		return !slices.ContainsFunc(values, func(v any) bool {
+			return slices.ContainsFunc(c.values, func(want any) bool { return compare(v, want) == 0 })
+		})
+	}
+	for _, v := range values {
+		for _, want := range c.values {
+			cmp := compare(v, want)
+			switch {
+			case c.op == "=" && cmp == 0,
+				c.op == ">" && cmp > 0,
+				c.op == ">=" && cmp >= 0,
+				c.op == "<" && cmp < 0 && cmp != incomparable,
+				c.op == "<=" && cmp <= 0 && cmp != incomparable:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const incomparable = -2
+
+// compare returns -1, 0 or 1, or incomparable if a and b have different
+// types.
+func compare(a, b any) int {
+	switch a := a.(type) {
+	case float64:
+		b, ok := b.(float64)
+		if !ok {
+			return incomparable
+		}
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		b, ok := b.(string)
+		if !ok {
+			return incomparable
+		}
+		return strings.Compare(a, b)
+	case bool:
+		b, ok := b.(bool)
+		if !ok || a != b {
+			return incomparable
+		}
+		return 0
+	}
+	return incomparable
+}
+
+// fieldValues returns the values of the dotted field path in msg. Messages
+// compare by their id and timestamps by their seconds, like in IGDB queries.
+// Repeated fields return one value per element.
+func fieldValues(msg protoreflect.Message, path string) []any {
+	name, rest, _ := strings.Cut(path, ".")
+	fd := msg.Descriptor().Fields().ByName(protoreflect.Name(name))
+	if fd == nil || !msg.Has(fd) {
+		return nil
+	}
+
+	values := []any{}
+	add := func(v protoreflect.Value) {
+		if fd.Kind() != protoreflect.MessageKind {
+			values = append(values, scalarValue(fd, v))
+			return
+		}
+		sub := v.Message()
+		switch {
+		case rest != "":
+			values = append(values, fieldValues(sub, rest)...)
+		case sub.Descriptor().FullName() == "google.protobuf.Timestamp":
+			values = append(values, fieldValues(sub, "seconds")...)
+		default:
+			values = append(values, fieldValues(sub, "id")...)
+		}
+	}
+	if fd.IsList() {
+		list := msg.Get(fd).List()
+		for i := range list.Len() {
+			add(list.Get(i))
+		}
+	} else {
+		add(msg.Get(fd))
+	}
+	return values
+}
+
+func scalarValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.EnumKind:
+		return float64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return float64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return float64(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	}
+	return nil
+}
+
+// project clears the top level fields of msg that are not selected by the
+// fields and exclude statements.
+func (q *query) project(msg protoreflect.Message) {
+	keep := map[string]bool{"id": true}
+	all := len(q.fields) == 0
+	for _, f := range q.fields {
+		if f == "*" {
+			all = true
+		}
+		name, _, _ := strings.Cut(f, ".")
+		keep[name] = true
+	}
+	cleared := []protoreflect.FieldDescriptor{}
+	msg.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		name := string(fd.Name())
+		if (!all && !keep[name]) || slices.Contains(q.exclude, name) {
+			cleared = append(cleared, fd)
+		}
+		return true
+	})
+	for _, fd := range cleared {
+		msg.Clear(fd)
+	}
+}
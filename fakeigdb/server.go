@@ -0,0 +1,426 @@
+// Package fakeigdb is a fake of the IGDB API and the Twitch token endpoint
+// for offline integration tests. Items are kept in memory and can be seeded
+// from fixture files or through the admin API under /fake/.
+package fakeigdb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"igdb-database/logging"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+var (
+	marshalOptions   = protojson.MarshalOptions{UseProtoNames: true}
+	unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+type Server struct {
+	mu          sync.RWMutex
+	collections map[endpoint.Name]*collection
+	tokens      map[string]bool
+	webhooks    []*webhook
+	nextHookId  uint64
+
+	ca    *certificateAuthority
+	certs sync.Map
+	mux   *http.ServeMux
+}
+
+// collection holds the items of one endpoint.
+type collection struct {
+	items      map[uint64]proto.Message
+	newItem    func() proto.Message
+	resultType protoreflect.MessageType
+	countType  protoreflect.MessageType
+}
+
+func New() (*Server, error) {
+	ca, err := newCertificateAuthority()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		collections: make(map[endpoint.Name]*collection),
+		tokens:      make(map[string]bool),
+		ca:          ca,
+		mux:         http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /oauth2/token", s.issueToken)
+	s.mux.HandleFunc("/v4/", s.requireToken(s.api))
+	s.mux.HandleFunc("PUT /fake/items/{endpoint}", s.putItems)
+	s.mux.HandleFunc("DELETE /fake/items/{endpoint}/{id}", s.deleteItem)
+	s.mux.HandleFunc("POST /fake/webhooks/trigger", s.triggerWebhook)
+	return s, nil
+}
+
+// Register adds the endpoint e to the fake. Only registered endpoints are
+// served.
+func Register[T any](s *Server, e endpoint.EntityEndpoint[T]) error {
+	newItem := func() proto.Message { return any(new(T)).(proto.Message) }
+	desc := newItem().ProtoReflect().Descriptor()
+
+	// IGDB wraps the items of a query in a <Type>Result message and counts in
+	// a Count message of the same package.
+	resultType, err := protoregistry.GlobalTypes.FindMessageByName(desc.FullName() + "Result")
+	if err != nil {
+		return fmt.Errorf("failed to find result message of %s: %w", desc.FullName(), err)
+	}
+	countType, err := protoregistry.GlobalTypes.FindMessageByName(desc.ParentFile().Package() + ".Count")
+	if err != nil {
+		return fmt.Errorf("failed to find count message: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.collections[e.GetEndpointName()] = &collection{
+		items:      make(map[uint64]proto.Message),
+		newItem:    newItem,
+		resultType: resultType,
+		countType:  countType,
+	}
+	return nil
+}
+
+// ServeHTTP serves the fake API. CONNECT requests are answered with a TLS
+// connection for the requested host, so the fake can be used as the HTTPS
+// proxy of clients with hard coded IGDB and Twitch urls.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		s.connect(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// LoadFixtures seeds the fake from dir. Every <endpoint>.json file holds a
+// JSON array of items in the protojson format with proto field names.
+func (s *Server) LoadFixtures(dir string) error {
+	s.mu.RLock()
+	names := slices.Sorted(maps.Keys(s.collections))
+	s.mu.RUnlock()
+
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, string(name)+".json"))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to read fixture %s: %w", name, err)
+		}
+		n, err := s.putJSON(name, data)
+		if err != nil {
+			return fmt.Errorf("failed to load fixture %s: %w", name, err)
+		}
+		slog.Info("fixture loaded", logging.KeyEndpoint, name, "items", n)
+	}
+	return nil
+}
+
+func (s *Server) putJSON(name endpoint.Name, data []byte) (int, error) {
+	s.mu.RLock()
+	coll := s.collections[name]
+	s.mu.RUnlock()
+	if coll == nil {
+		return 0, fmt.Errorf("unknown endpoint %s", name)
+	}
+
+	raws := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return 0, err
+	}
+	items := make([]proto.Message, 0, len(raws))
+	for _, raw := range raws {
+		item := coll.newItem()
+		if err := unmarshalOptions.Unmarshal(raw, item); err != nil {
+			return 0, err
+		}
+		items = append(items, item)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range items {
+		coll.items[itemId(item)] = item
+	}
+	return len(items), nil
+}
+
+func (s *Server) getItem(name endpoint.Name, id uint64) (proto.Message, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	coll := s.collections[name]
+	if coll == nil {
+		return nil, false
+	}
+	item, ok := coll.items[id]
+	return item, ok
+}
+
+func itemId(item proto.Message) uint64 {
+	msg := item.ProtoReflect()
+	fd := msg.Descriptor().Fields().ByName("id")
+	if fd == nil {
+		return 0
+	}
+	return msg.Get(fd).Uint()
+}
+
+func (s *Server) issueToken(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("client_id") == "" || r.URL.Query().Get("client_secret") == "" {
+		http.Error(w, "missing client credentials", http.StatusBadRequest)
+		return
+	}
+	token := randomHex(15)
+	s.mu.Lock()
+	s.tokens[token] = true
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": token,
+		"expires_in":   5184000,
+		"token_type":   "bearer",
+	})
+}
+
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		s.mu.RLock()
+		ok := s.tokens[token]
+		s.mu.RUnlock()
+		if r.Header.Get("Client-ID") == "" || !ok {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// api serves /v4/<endpoint>, /v4/<endpoint>/count, /v4/<endpoint>/webhooks
+// and /v4/webhooks, with or without the .pb suffix.
+func (s *Server) api(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/v4/"), "/")
+	path, protobuf := strings.CutSuffix(path, ".pb")
+	name, action, _ := strings.Cut(path, "/")
+
+	if name == "webhooks" {
+		if action == "" {
+			s.listWebhooks(w, r)
+		} else {
+			s.deleteWebhook(w, r, action)
+		}
+		return
+	}
+	s.mu.RLock()
+	coll := s.collections[endpoint.Name(name)]
+	s.mu.RUnlock()
+	if coll == nil {
+		http.Error(w, "unknown endpoint "+name, http.StatusNotFound)
+		return
+	}
+
+	switch action {
+	case "":
+		s.query(w, r, coll, protobuf)
+	case "count":
+		s.count(w, r, coll, protobuf)
+	case "webhooks":
+		s.webhookRequest(w, r, endpoint.Name(name))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) query(w http.ResponseWriter, r *http.Request, coll *collection, protobuf bool) {
+	q, ok := readQuery(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	items := make([]protoreflect.Message, 0, len(coll.items))
+	for _, item := range coll.items {
+		msg := item.ProtoReflect()
+		if q.matches(msg) {
+			items = append(items, msg)
+		}
+	}
+	s.mu.RUnlock()
+
+	sortField := q.sort
+	if sortField == "" {
+		sortField = "id"
+	}
+	slices.SortStableFunc(items, func(a, b protoreflect.Message) int {
+		av, bv := fieldValues(a, sortField), fieldValues(b, sortField)
+		cmp := 0
+		switch {
+		case len(av) == 0 && len(bv) > 0:
+			cmp = -1
+		case len(av) > 0 && len(bv) == 0:
+			cmp = 1
+		case len(av) > 0:
+			cmp = max(compare(av[0], bv[0]), -1)
+		}
+		if q.sortDesc {
+			return -cmp
+		}
+		return cmp
+	})
+	items = items[min(q.offset, len(items)):min(q.offset+q.limit, len(items))]
+
+	result := coll.resultType.New()
+	list := result.Mutable(result.Descriptor().Fields().Get(0)).List()
+	for _, item := range items {
+		msg := proto.Clone(item.Interface()).ProtoReflect()
+		q.project(msg)
+		list.Append(protoreflect.ValueOfMessage(msg))
+	}
+
+	if protobuf {
+		writeProto(w, result.Interface())
+		return
+	}
+	// the JSON API returns a plain array
+	data := []byte("[")
+	for i := range list.Len() {
+		if i > 0 {
+			data = append(data, ',')
+		}
+		b, err := marshalOptions.Marshal(list.Get(i).Message().Interface())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = append(data, b...)
+	}
+	data = append(data, ']')
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func (s *Server) count(w http.ResponseWriter, r *http.Request, coll *collection, protobuf bool) {
+	q, ok := readQuery(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.RLock()
+	n := 0
+	for _, item := range coll.items {
+		if q.matches(item.ProtoReflect()) {
+			n++
+		}
+	}
+	s.mu.RUnlock()
+
+	if !protobuf {
+		writeJSON(w, http.StatusOK, map[string]int{"count": n})
+		return
+	}
+	result := coll.countType.New()
+	fd := result.Descriptor().Fields().ByName("count")
+	switch fd.Kind() {
+	case protoreflect.Uint32Kind, protoreflect.Uint64Kind, protoreflect.Fixed32Kind, protoreflect.Fixed64Kind:
+		result.Set(fd, protoreflect.ValueOfUint64(uint64(n)))
+	case protoreflect.Int32Kind:
+		result.Set(fd, protoreflect.ValueOfInt32(int32(n)))
+	default:
+		result.Set(fd, protoreflect.ValueOfInt64(int64(n)))
+	}
+	writeProto(w, result.Interface())
+}
+
+func readQuery(w http.ResponseWriter, r *http.Request) (*query, bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return nil, false
+	}
+	q, err := parseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return q, true
+}
+
+// putItems adds or replaces the items in the request body, a JSON array like
+// in the fixture files.
+func (s *Server) putItems(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+	n, err := s.putJSON(endpoint.Name(r.PathValue("endpoint")), body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"saved": n})
+}
+
+func (s *Server) deleteItem(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	coll := s.collections[endpoint.Name(r.PathValue("endpoint"))]
+	if coll != nil {
+		delete(coll.items, id)
+	}
+	s.mu.Unlock()
+	if coll == nil {
+		http.Error(w, "unknown endpoint", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeProto(w http.ResponseWriter, msg proto.Message) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/protobuf")
+	_, _ = w.Write(data)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", logging.Err(err))
+	}
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
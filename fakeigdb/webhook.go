@@ -0,0 +1,220 @@
+package fakeigdb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"igdb-database/logging"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// webhook is a webhook registration, the fields follow the IGDB API.
+type webhook struct {
+	Id          uint64        `json:"id"`
+	Url         string        `json:"url"`
+	Category    endpoint.Name `json:"category"`
+	SubCategory string        `json:"sub_category"`
+	Active      bool          `json:"active"`
+	Secret      string        `json:"secret"`
+	CreatedAt   int64         `json:"created_at"`
+	UpdatedAt   int64         `json:"updated_at"`
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+func (s *Server) webhookRequest(w http.ResponseWriter, r *http.Request, name endpoint.Name) {
+	if r.Method != http.MethodPost {
+		s.listWebhooks(w, r)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	method := r.PostForm.Get("method")
+	if r.PostForm.Get("url") == "" || r.PostForm.Get("secret") == "" ||
+		(method != "create" && method != "update" && method != "delete") {
+		http.Error(w, "url, secret and method are required", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	s.mu.Lock()
+	s.nextHookId++
+	hook := &webhook{
+		Id:          s.nextHookId,
+		Url:         r.PostForm.Get("url"),
+		Category:    name,
+		SubCategory: method,
+		Active:      true,
+		Secret:      r.PostForm.Get("secret"),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	s.webhooks = append(s.webhooks, hook)
+	s.mu.Unlock()
+
+	slog.Info("webhook registered", logging.KeyEndpoint, name, "method", method, "url", hook.Url)
+	writeJSON(w, http.StatusOK, hook)
+}
+
+func (s *Server) listWebhooks(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	writeJSON(w, http.StatusOK, s.webhooks)
+}
+
+func (s *Server) deleteWebhook(w http.ResponseWriter, r *http.Request, idParam string) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(idParam, 10, 64)
+	if err != nil {
+		http.Error(w, "invalid webhook id", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.webhooks, func(hook *webhook) bool { return hook.Id == id })
+	if i == -1 {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	hook := s.webhooks[i]
+	s.webhooks = slices.Delete(s.webhooks, i, i+1)
+	writeJSON(w, http.StatusOK, hook)
+}
+
+// triggerWebhook calls the webhooks registered for an item the way IGDB
+// does, with the item as body and the secret in the X-Secret header.
+// Webhooks can also be called without a registration by passing url and
+// secret.
+func (s *Server) triggerWebhook(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Endpoint endpoint.Name `json:"endpoint"`
+		Method   string        `json:"method"`
+		Id       uint64        `json:"id"`
+		Url      string        `json:"url"`
+		Secret   string        `json:"secret"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	hooks := []*webhook{}
+	if req.Url != "" {
+		hooks = append(hooks, &webhook{Url: req.Url, Secret: req.Secret})
+	} else {
+		s.mu.RLock()
+		for _, hook := range s.webhooks {
+			if hook.Category == req.Endpoint && hook.SubCategory == req.Method {
+				hooks = append(hooks, hook)
+			}
+		}
+		s.mu.RUnlock()
+	}
+
+	body := []byte(fmt.Sprintf(`{"id":%d}`, req.Id))
+	if req.Method != "delete" {
+		item, ok := s.getItem(req.Endpoint, req.Id)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%s %d not found", req.Endpoint, req.Id), http.StatusNotFound)
+			return
+		}
+		var err error
+		body, err = webhookBody(item)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	type delivery struct {
+		Url    string `json:"url"`
+		Status int    `json:"status,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+	deliveries := []delivery{}
+	for _, hook := range hooks {
+		d := delivery{Url: hook.Url}
+		status, err := deliver(hook, body)
+		if err != nil {
+			d.Error = err.Error()
+		}
+		d.Status = status
+		deliveries = append(deliveries, d)
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// webhookBody marshals an item as IGDB sends it to webhooks. protojson
+// quotes 64-bit integers, IGDB sends them as numbers.
+func webhookBody(item proto.Message) ([]byte, error) {
+	data, err := marshalOptions.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	unquoteIntegers(item.ProtoReflect().Descriptor(), value)
+	return json.Marshal(value)
+}
+
+func unquoteIntegers(md protoreflect.MessageDescriptor, value map[string]any) {
+	for name, v := range value {
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			continue
+		}
+		values := []any{v}
+		if list, ok := v.([]any); ok {
+			values = list
+		}
+		for i, v := range values {
+			switch fd.Kind() {
+			case protoreflect.MessageKind:
+				if m, ok := v.(map[string]any); ok {
+					unquoteIntegers(fd.Message(), m)
+				}
+			case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind,
+				protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+				if s, ok := v.(string); ok {
+					values[i] = json.Number(s)
+				}
+			}
+		}
+		if _, ok := v.([]any); !ok {
+			value[name] = values[0]
+		}
+	}
+}
+
+func deliver(hook *webhook, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Secret", hook.Secret)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
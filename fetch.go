@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/collector"
+	"igdb-database/db"
+	"igdb-database/logging"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+// igdbEndpoint is an entity endpoint that is stored locally.
+type igdbEndpoint struct {
+	name  endpoint.Name
+	count func() (uint64, error)
+	fetch func(ctx context.Context, s db.Store, opts *fetchOptions, res *fetchResult)
+}
+
+func newIgdbEndpoint[T any](e endpoint.EntityEndpoint[T]) *igdbEndpoint {
+	return &igdbEndpoint{
+		name:  e.GetEndpointName(),
+		count: e.Count,
+		fetch: func(ctx context.Context, s db.Store, opts *fetchOptions, res *fetchResult) {
+			fetchAndStore(ctx, s, e, opts, res)
+		},
+	}
+}
+
+// allEndpoints returns the stored endpoints.
+func allEndpoints(client *igdb.Client) []*igdbEndpoint {
+	return []*igdbEndpoint{
+		newIgdbEndpoint(client.AgeRatingCategories),
+		newIgdbEndpoint(client.AgeRatingContentDescriptions),
+		newIgdbEndpoint(client.AgeRatingContentDescriptionsV2),
+		newIgdbEndpoint(client.AgeRatingOrganizations),
+		newIgdbEndpoint(client.AgeRatings),
+		newIgdbEndpoint(client.AlternativeNames),
+		newIgdbEndpoint(client.Artworks),
+		newIgdbEndpoint(client.CharacterGenders),
+		newIgdbEndpoint(client.CharacterMugShots),
+		newIgdbEndpoint(client.Characters),
+		newIgdbEndpoint(client.CharacterSpecies),
+		newIgdbEndpoint(client.CollectionMemberships),
+		newIgdbEndpoint(client.CollectionMembershipTypes),
+		newIgdbEndpoint(client.CollectionRelations),
+		newIgdbEndpoint(client.CollectionRelationTypes),
+		newIgdbEndpoint(client.Collections),
+		newIgdbEndpoint(client.CollectionTypes),
+		newIgdbEndpoint(client.Companies),
+		newIgdbEndpoint(client.CompanyLogos),
+		newIgdbEndpoint(client.CompanyStatuses),
+		newIgdbEndpoint(client.CompanyWebsites),
+		newIgdbEndpoint(client.Covers),
+		newIgdbEndpoint(client.DateFormats),
+		newIgdbEndpoint(client.EventLogos),
+		newIgdbEndpoint(client.EventNetworks),
+		newIgdbEndpoint(client.Events),
+		newIgdbEndpoint(client.ExternalGames),
+		newIgdbEndpoint(client.ExternalGameSources),
+		newIgdbEndpoint(client.Franchises),
+		newIgdbEndpoint(client.GameEngineLogos),
+		newIgdbEndpoint(client.GameEngines),
+		newIgdbEndpoint(client.GameLocalizations),
+		newIgdbEndpoint(client.GameModes),
+		newIgdbEndpoint(client.GameReleaseFormats),
+		newIgdbEndpoint(client.GameStatuses),
+		newIgdbEndpoint(client.GameTimeToBeats),
+		newIgdbEndpoint(client.GameTypes),
+		newIgdbEndpoint(client.GameVersionFeatures),
+		newIgdbEndpoint(client.GameVersionFeatureValues),
+		newIgdbEndpoint(client.GameVersions),
+		newIgdbEndpoint(client.GameVideos),
+		newIgdbEndpoint(client.Genres),
+		newIgdbEndpoint(client.InvolvedCompanies),
+		newIgdbEndpoint(client.Keywords),
+		newIgdbEndpoint(client.Languages),
+		newIgdbEndpoint(client.LanguageSupports),
+		newIgdbEndpoint(client.LanguageSupportTypes),
+		newIgdbEndpoint(client.MultiplayerModes),
+		newIgdbEndpoint(client.NetworkTypes),
+		newIgdbEndpoint(client.PlatformFamilies),
+		newIgdbEndpoint(client.PlatformLogos),
+		newIgdbEndpoint(client.Platforms),
+		newIgdbEndpoint(client.PlatformTypes),
+		newIgdbEndpoint(client.PlatformVersionCompanies),
+		newIgdbEndpoint(client.PlatformVersionReleaseDates),
+		newIgdbEndpoint(client.PlatformVersions),
+		newIgdbEndpoint(client.PlatformWebsites),
+		newIgdbEndpoint(client.PlayerPerspectives),
+		newIgdbEndpoint(client.PopularityPrimitives),
+		newIgdbEndpoint(client.PopularityTypes),
+		newIgdbEndpoint(client.Regions),
+		newIgdbEndpoint(client.ReleaseDateRegions),
+		newIgdbEndpoint(client.ReleaseDates),
+		newIgdbEndpoint(client.ReleaseDateStatuses),
+		newIgdbEndpoint(client.Screenshots),
+		newIgdbEndpoint(client.Themes),
+		newIgdbEndpoint(client.Websites),
+		newIgdbEndpoint(client.WebsiteTypes),
+		newIgdbEndpoint(client.Games),
+	}
+}
+
+// selectEndpoints returns the endpoints with the given names, or all of them
+// if names is empty, without the excluded ones. They are ordered so that
+// referenced collections are fetched first.
+func selectEndpoints(all []*igdbEndpoint, names []string, exclude []string) ([]*igdbEndpoint, error) {
+	byName := make(map[endpoint.Name]*igdbEndpoint, len(all))
+	for _, e := range all {
+		byName[e.name] = e
+	}
+	for _, name := range slices.Concat(names, exclude) {
+		if _, ok := byName[endpoint.Name(name)]; !ok {
+			return nil, fmt.Errorf("unknown endpoint %q", name)
+		}
+	}
+
+	selected := make([]endpoint.Name, 0, len(all))
+	if len(names) == 0 {
+		for _, e := range all {
+			selected = append(selected, e.name)
+		}
+	} else {
+		for _, name := range names {
+			if !slices.Contains(selected, endpoint.Name(name)) {
+				selected = append(selected, endpoint.Name(name))
+			}
+		}
+	}
+	selected = slices.DeleteFunc(selected, func(e endpoint.Name) bool {
+		return slices.Contains(exclude, string(e))
+	})
+
+	res := make([]*igdbEndpoint, 0, len(selected))
+	for _, name := range collector.FetchOrder(selected) {
+		res = append(res, byName[name])
+	}
+	return res, nil
+}
+
+type fetchOptions struct {
+	reFetch     bool
+	restart     bool
+	incremental bool
+}
+
+type fetchResult struct {
+	updatedGameIds map[uint64]bool
+	failedPages    map[endpoint.Name][]uint64
+	failed         []endpoint.Name
+	// aggregateAll is set when an incremental fetch fetched a collection
+	// in full, so every game may have changed.
+	aggregateAll bool
+}
+
+func runFetch(ctx context.Context, args []string) int {
+	fs := newFlagSet("fetch", "fetch [flags] [endpoints...]")
+	reFetch := fs.Bool("re-fetch", false, "re fetch all selected endpoints even if their collection is not empty")
+	exclude := fs.String("exclude", "", "comma separated endpoints not to fetch")
+	incremental := fs.Bool("incremental", false, "only fetch items updated since the last sync and re aggregate affected games")
+	metricsAddress := fs.String("metrics", "", "serve /metrics on this address while fetching")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	client := newClient()
+	excluded := []string{}
+	if *exclude != "" {
+		for _, name := range strings.Split(*exclude, ",") {
+			excluded = append(excluded, strings.TrimSpace(name))
+		}
+	}
+	endpoints, err := selectEndpoints(allEndpoints(client), fs.Args(), excluded)
+	if err != nil {
+		slog.Error("invalid endpoints", logging.Err(err))
+		return exitUsage
+	}
+	s := newStore(ctx)
+	serveMetrics(ctx, *metricsAddress, s)
+	run := startRun(ctx, s, "fetch")
+
+	// endpoints named explicitly are always fetched, -re-fetch also discards
+	// unfinished fetches instead of resuming them
+	opts := &fetchOptions{reFetch: *reFetch || fs.NArg() > 0, restart: *reFetch, incremental: *incremental}
+	res := &fetchResult{
+		updatedGameIds: map[uint64]bool{},
+		failedPages:    map[endpoint.Name][]uint64{},
+	}
+	slog.Info("fetching data")
+	for _, e := range endpoints {
+		if ctx.Err() != nil {
+			break
+		}
+		e.fetch(ctx, s, opts, res)
+	}
+	if ctx.Err() != nil {
+		slog.Warn("fetch interrupted, run fetch again to resume it")
+		return finishRun(ctx, s, run, exitError, "fetch interrupted")
+	}
+	slog.Info("data fetched")
+	reportFailedPages(res)
+
+	if opts.incremental && res.aggregateAll {
+		slog.Info("collections were fetched in full, aggregating all games")
+		aggregated, err := aggregateGames(ctx, s, client, true)
+		if err == nil {
+			err = aggregateViews(ctx, s, client)
+		}
+		if err != nil {
+			slog.Error("failed to aggregate games", "aggregated", aggregated, logging.Err(err))
+			return finishRun(ctx, s, run, exitError, err.Error())
+		}
+		slog.Info("games aggregated", "aggregated", aggregated)
+	} else if opts.incremental {
+		slog.Info("aggregating updated games", "games", len(res.updatedGameIds))
+		_, err := aggregateGamesByIds(ctx, s, client, slices.Collect(maps.Keys(res.updatedGameIds)), true)
+		if err != nil {
+			slog.Error("failed to aggregate updated games", logging.Err(err))
+			return finishRun(ctx, s, run, exitError, err.Error())
+		}
+		slog.Info("updated games aggregated")
+	}
+
+	switch {
+	case len(res.failed) > 0:
+		slog.Error("failed to fetch endpoints", "endpoints", res.failed)
+		return finishRun(ctx, s, run, exitError, fmt.Sprintf("failed to fetch %v", res.failed))
+	case len(res.failedPages) > 0:
+		return finishRun(ctx, s, run, exitIncomplete, fmt.Sprintf("pages failed for %d endpoints", len(res.failedPages)))
+	}
+	return finishRun(ctx, s, run, exitOK, fmt.Sprintf("%d endpoints fetched", len(endpoints)))
+}
+
+func fetchAndStore[T any](
+	ctx context.Context,
+	s db.Store,
+	e endpoint.EntityEndpoint[T],
+	opts *fetchOptions,
+	res *fetchResult,
+) {
+	if opts.incremental {
+		ids, fetchedAll, err := collector.FetchUpdatedAndStore(ctx, s, e)
+		res.aggregateAll = res.aggregateAll || fetchedAll
+		if err != nil {
+			slog.Error("failed to fetch updated items", logging.KeyEndpoint, e.GetEndpointName(), logging.Err(err))
+			res.failed = append(res.failed, e.GetEndpointName())
+			return
+		}
+		for _, id := range ids {
+			res.updatedGameIds[id] = true
+		}
+		return
+	}
+
+	if count, err := s.EstimatedDocumentCount(ctx, e.GetEndpointName()); (err == nil && count == 0) || opts.reFetch || collector.IsFetchUnfinished(ctx, s, e.GetEndpointName()) {
+		failed, err := collector.FetchAndStore(ctx, s, e, opts.restart)
+		if err != nil {
+			slog.Error("failed to fetch items", logging.KeyEndpoint, e.GetEndpointName(), logging.Err(err))
+			res.failed = append(res.failed, e.GetEndpointName())
+			return
+		}
+		if len(failed) > 0 {
+			res.failedPages[e.GetEndpointName()] = failed
+		}
+	} else if err != nil {
+		slog.Error("failed to count items", logging.KeyEndpoint, e.GetEndpointName(), logging.Err(err))
+		res.failed = append(res.failed, e.GetEndpointName())
+	}
+}
+
+func reportFailedPages(res *fetchResult) {
+	if len(res.failedPages) == 0 {
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(res.failedPages)) {
+		slog.Warn("pages failed", logging.KeyEndpoint, name, "pages", len(res.failedPages[name]), "offsets", res.failedPages[name])
+	}
+	slog.Warn("some pages failed, run fetch again to retry them")
+}
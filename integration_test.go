@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"igdb-database/collector"
+	"igdb-database/config"
+	"igdb-database/db"
+	"igdb-database/fakeigdb"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bestnite/go-igdb"
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+// TestFakeIGDB fetches and aggregates a game from a fake IGDB, then updates
+// its genre through a signed webhook of the fake.
+func TestFakeIGDB(t *testing.T) {
+	if testing.Short() {
+		t.Skip("integration test")
+	}
+
+	fake, err := fakeigdb.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fakeigdb.RegisterAll(fake, igdb.New("fake", "fake")); err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(fake)
+	defer proxy.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := fake.WriteCACert(caFile); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	t.Setenv("IGDB_STORAGE", "memory")
+	t.Setenv("IGDB_TWITCH_CLIENT_ID", "id")
+	t.Setenv("IGDB_TWITCH_CLIENT_SECRET", "secret")
+	t.Setenv("IGDB_WEBHOOK_SECRET", "webhook-secret")
+	t.Setenv("IGDB_ADDRESS", address)
+	t.Setenv("IGDB_EXTERNAL_URL", "http://"+address)
+	t.Setenv("IGDB_PROXY", proxy.URL)
+	t.Setenv("IGDB_CA_FILE", caFile)
+	// restored after the test, useFakeIGDB sets them
+	t.Setenv("HTTPS_PROXY", "")
+	t.Setenv("SSL_CERT_FILE", "")
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := useFakeIGDB(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeRequest(t, http.MethodPut, proxy.URL+"/fake/items/games", `[{"id": 1, "name": "Zelda", "genres": [{"id": 10}]}]`)
+	fakeRequest(t, http.MethodPut, proxy.URL+"/fake/items/genres", `[{"id": 10, "name": "Adventure"}]`)
+
+	ctx := context.Background()
+	client := newClient()
+	s := db.NewMemoryStore()
+	endpoints, err := selectEndpoints(allEndpoints(client), []string{"games", "genres"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := &fetchResult{
+		updatedGameIds: map[uint64]bool{},
+		failedPages:    map[endpoint.Name][]uint64{},
+	}
+	for _, e := range endpoints {
+		e.fetch(ctx, s, &fetchOptions{reFetch: true}, res)
+	}
+	if len(res.failed) > 0 || len(res.failedPages) > 0 {
+		t.Fatalf("fetch failed: endpoints %v, pages %v", res.failed, res.failedPages)
+	}
+	if _, err := aggregateGamesByIds(ctx, s, client, []uint64{1}, true); err != nil {
+		t.Fatal(err)
+	}
+	waitForGenre(t, s, "Adventure")
+
+	serveCtx, stop := context.WithCancel(ctx)
+	served := make(chan error, 1)
+	go func() {
+		served <- collector.StartWebhookServer(serveCtx, s, client, true)
+	}()
+	defer func() {
+		stop()
+		if err := <-served; err != nil {
+			t.Errorf("webhook server failed: %v", err)
+		}
+	}()
+
+	fakeRequest(t, http.MethodPut, proxy.URL+"/fake/items/genres", `[{"id": 10, "name": "Action"}]`)
+	// the webhooks are registered in the background, trigger until the
+	// genre webhook is delivered
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		body := fakeRequest(t, http.MethodPost, proxy.URL+"/fake/webhooks/trigger", `{"endpoint": "genres", "method": "update", "id": 10}`)
+		if strings.Contains(body, `"status":200`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("genre webhook was not delivered: %s", body)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	waitForGenre(t, s, "Action")
+}
+
+func fakeRequest(t *testing.T, method string, url string, body string) string {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s returned %d: %s", method, url, resp.StatusCode, data)
+	}
+	return string(data)
+}
+
+// waitForGenre waits until the aggregated game 1 has the genre name.
+func waitForGenre(t *testing.T, s db.Store, name string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		game, err := s.GetGameById(context.Background(), 1)
+		got := ""
+		if err == nil && len(game.Genres) == 1 {
+			got = game.Genres[0].Name
+		}
+		if got == name {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("genre of game 1 is %q, want %q (err %v)", got, name, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Keys of the fields that identify what a log line is about, so failures can
+// be queried by endpoint or traced through the processing of one webhook.
+const (
+	KeyEndpoint       = "endpoint"
+	KeyEntityId       = "entity_id"
+	KeyGameId         = "game_id"
+	KeyWebhookEventId = "webhook_event_id"
+	KeyRequestId      = "request_id"
+	KeyError          = "error"
+)
+
+// Setup makes slog write lines of at least level in format, "text" or
+// "json", to stderr. The log package writes through it as well.
+func Setup(level string, format string) error {
+	handler, err := NewHandler(os.Stderr, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// NewHandler returns the handler Setup installs, writing to w.
+func NewHandler(w io.Writer, level string, format string) (slog.Handler, error) {
+	l, err := ParseLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: l}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("log format %q is not text or json", format)
+	}
+}
+
+// ParseLevel parses debug, info, warn or error. An empty level is info.
+func ParseLevel(level string) (slog.Level, error) {
+	if level == "" {
+		return slog.LevelInfo, nil
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return 0, fmt.Errorf("log level %q is not debug, info, warn or error", level)
+	}
+	return l, nil
+}
+
+type loggerKey struct{}
+
+// With returns a context whose logger adds args to every line, e.g. the
+// endpoint and id of the entity being processed.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, From(ctx).With(args...))
+}
+
+// From returns the logger of ctx, or the default logger.
+func From(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Err is the field of an error.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+// NewRequestId returns a random id that correlates the lines logged for one
+// request.
+func NewRequestId() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,196 +1,206 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"igdb-database/collector"
+	"fmt"
 	"igdb-database/config"
 	"igdb-database/db"
-	"log"
-	"sync"
-	"sync/atomic"
+	"igdb-database/logging"
+	"igdb-database/metrics"
+	"igdb-database/model"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/bestnite/go-igdb"
-	"github.com/bestnite/go-igdb/endpoint"
-	pb "github.com/bestnite/go-igdb/proto"
 )
 
-var (
-	enableAggregate   = flag.Bool("aggregate", false, "aggregate games")
-	enableFetch       = flag.Bool("fetch", false, "fetch data")
-	enableReFetch     = flag.Bool("re-fetch", false, "re fetch data even if collection is not empty")
-	enableReAggregate = flag.Bool("re-aggregate", false, "re aggregate games even if game_details is not empty")
-	enableWebhook     = flag.Bool("webhook", true, "start webhook server")
-	onlyRefetchGames  = flag.Bool("only-refetch-games", false, "only refetch games")
+// Exit codes of the commands.
+const (
+	exitOK = 0
+	// exitError is returned when a command failed.
+	exitError = 1
+	// exitUsage is returned for invalid arguments.
+	exitUsage = 2
+	// exitIncomplete is returned when a command finished but left work
+	// behind, e.g. failed pages of a fetch or differences found by verify.
+	exitIncomplete = 3
 )
 
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) int
+}
+
+var commands = []*command{
+	{"fetch", "fetch [flags] [endpoints...]  fetch items from IGDB", runFetch},
+	{"aggregate", "aggregate [flags]              aggregate games into game_details", runAggregate},
+	{"serve", "serve [flags]                  start the webhook server and the query API", runServe},
+	{"webhooks", "webhooks list|register|unregister  manage the webhooks registered with IGDB", runWebhooks},
+	{"status", "status                         show the fetch progress and collection sizes", runStatus},
+	{"verify", "verify [endpoints...]          compare local collections with IGDB", runVerify},
+}
+
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	flag.Usage = usage
+	configPath := flag.String("config", "", "path of the JSON or YAML config file (default $IGDB_CONFIG or config.json if present)")
 	flag.Parse()
 
-	client := igdb.New(config.C().Twitch.ClientID, config.C().Twitch.ClientSecret)
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			cfg, err := config.Load(findConfig(*configPath))
+			if err != nil {
+				slog.Error("failed to load config", logging.Err(err))
+				os.Exit(exitUsage)
+			}
+			if err := logging.Setup(cfg.Log.Level, cfg.Log.Format); err != nil {
+				slog.Error("failed to set up logging", logging.Err(err))
+				os.Exit(exitUsage)
+			}
+			if err := useFakeIGDB(cfg); err != nil {
+				slog.Error("failed to use fake igdb", logging.Err(err))
+				os.Exit(exitError)
+			}
+			// commands stop starting new work on SIGINT or SIGTERM and
+			// finish the work in flight before they return
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			code := c.run(ctx, flag.Args()[1:])
+			stop()
+			os.Exit(code)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+	usage()
+	os.Exit(exitUsage)
+}
 
-	if *enableFetch || *enableReFetch {
-		log.Printf("fetching data")
-		allFetchAndStore(client)
-		log.Printf("data fetched")
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-config file] <command> [arguments]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.usage)
 	}
+	fmt.Fprintf(os.Stderr, "\nrun %s <command> -h for the flags of a command\n", os.Args[0])
+}
 
-	if *enableAggregate || *enableReAggregate {
-		log.Printf("aggregating games")
-		aggregateGames(client)
-		log.Printf("games aggregated")
+// findConfig returns the config file to load. Without a file the
+// configuration is read from the environment only.
+func findConfig(path string) string {
+	if path != "" {
+		return path
 	}
+	if path := os.Getenv("IGDB_CONFIG"); path != "" {
+		return path
+	}
+	if _, err := os.Stat("config.json"); err == nil {
+		return "config.json"
+	}
+	return ""
+}
 
-	if *enableWebhook {
-		log.Printf("starting webhook server")
-		collector.StartWebhookServer(client)
+// newFlagSet returns the flag set of a command. Parse errors are returned
+// instead of exiting, so commands can exit with exitUsage.
+func newFlagSet(name string, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s %s\n\nflags:\n", os.Args[0], usage)
+		fs.PrintDefaults()
 	}
+	return fs
 }
 
-func aggregateGames(client *igdb.Client) {
-	total, err := db.EstimatedDocumentCount(endpoint.EPGames)
-	if err != nil {
-		log.Fatalf("failed to count games: %v", err)
+func newClient() *igdb.Client {
+	return igdb.New(config.C().Twitch.ClientID, config.C().Twitch.ClientSecret)
+}
+
+// useFakeIGDB routes all HTTPS requests of the process to a fake IGDB if
+// configured. The igdb client has fixed urls and no way to pass an HTTP
+// client, so the fake is used as the HTTPS proxy of the process and its CA
+// replaces the system certificates. net/http and crypto/x509 read these
+// variables once, so it has to run before the first HTTPS request.
+func useFakeIGDB(cfg *config.Config) error {
+	if cfg.IGDB.Proxy == "" {
+		return nil
 	}
-	log.Printf("games length: %d", total)
-
-	finished := int64(0)
-	wg := sync.WaitGroup{}
-
-	concurrenceNum := 10
-	taskOneLoop := int64(500)
-
-	concurrence := make(chan struct{}, concurrenceNum)
-	defer close(concurrence)
-	for i := int64(0); i < total; i += taskOneLoop {
-		concurrence <- struct{}{}
-		wg.Add(1)
-		go func(i int64) {
-			defer func() { <-concurrence }()
-			defer wg.Done()
-			items, err := db.GetItemsPaginated[pb.Game](endpoint.EPGames, i, taskOneLoop)
-			if err != nil {
-				log.Fatalf("failed to get games: %v", err)
-			}
-			isAggregated := make(map[uint64]bool, len(items))
-			if !*enableReAggregate {
-				isAggregated, err = db.IsGamesAggregated(items)
-				if err != nil {
-					log.Fatalf("failed to check if games are aggregated: %v", err)
-				}
-			} else {
-				for _, game := range items {
-					isAggregated[game.Id] = false
-				}
-			}
-			for _, item := range items {
-				if isAggregated[item.Id] {
-					p := atomic.AddInt64(&finished, 1)
-					log.Printf("game aggregated %d/%d", p, total)
-					continue
-				}
-
-				game, err := db.ConvertGame(item, client)
-				if err != nil {
-					log.Fatalf("failed to convert game: %v", err)
-				}
-
-				err = db.SaveGame(game)
-				if err != nil {
-					log.Fatalf("failed to save game: %v", err)
-				}
-				p := atomic.AddInt64(&finished, 1)
-				log.Printf("game aggregated %d/%d", p, total)
-			}
-		}(i)
+	slog.Info("using fake igdb", "proxy", cfg.IGDB.Proxy)
+	if err := os.Setenv("HTTPS_PROXY", cfg.IGDB.Proxy); err != nil {
+		return fmt.Errorf("failed to set proxy: %w", err)
+	}
+	if cfg.IGDB.CAFile != "" {
+		if err := os.Setenv("SSL_CERT_FILE", cfg.IGDB.CAFile); err != nil {
+			return fmt.Errorf("failed to set ca file: %w", err)
+		}
 	}
-	wg.Wait()
+	return nil
 }
 
-func fetchAndStore[T any](
-	e endpoint.EntityEndpoint[T],
-) {
-	if count, err := db.EstimatedDocumentCount(e.GetEndpointName()); (err == nil && count == 0) || *enableReFetch {
-		collector.FetchAndStore(e)
-	} else if err != nil {
-		log.Printf("failed to count items: %v", err)
+// serveMetrics serves /metrics in the background if address is set, so the
+// batch commands can be scraped while they run. The server is closed when
+// ctx is cancelled.
+func serveMetrics(ctx context.Context, address string, s db.Store) {
+	if address == "" {
+		return
 	}
+	metrics.RegisterStore(s)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	server := &http.Server{Addr: address, Handler: mux}
+	go func() {
+		slog.Info("serving metrics", "address", address)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("failed to serve metrics", logging.Err(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		if err := server.Close(); err != nil {
+			slog.Error("failed to close metrics server", logging.Err(err))
+		}
+	}()
 }
 
-func allFetchAndStore(client *igdb.Client) {
-	if *onlyRefetchGames {
-		fetchAndStore(client.Games)
-		return
+// startRun records that command started, so the status page also shows runs
+// that are still going or were killed.
+func startRun(ctx context.Context, s db.Store, command string) *model.Run {
+	run := &model.Run{Command: command, StartedAt: time.Now()}
+	if err := s.SaveRun(ctx, run); err != nil {
+		slog.Error("failed to save run", "command", command, logging.Err(err))
 	}
+	return run
+}
 
-	fetchAndStore(client.AgeRatingCategories)
-	fetchAndStore(client.AgeRatingContentDescriptions)
-	fetchAndStore(client.AgeRatingContentDescriptionsV2)
-	fetchAndStore(client.AgeRatingOrganizations)
-	fetchAndStore(client.AgeRatings)
-	fetchAndStore(client.AlternativeNames)
-	fetchAndStore(client.Artworks)
-	fetchAndStore(client.CharacterGenders)
-	fetchAndStore(client.CharacterMugShots)
-	fetchAndStore(client.Characters)
-	fetchAndStore(client.CharacterSpecies)
-	fetchAndStore(client.CollectionMemberships)
-	fetchAndStore(client.CollectionMembershipTypes)
-	fetchAndStore(client.CollectionRelations)
-	fetchAndStore(client.CollectionRelationTypes)
-	fetchAndStore(client.Collections)
-	fetchAndStore(client.CollectionTypes)
-	fetchAndStore(client.Companies)
-	fetchAndStore(client.CompanyLogos)
-	fetchAndStore(client.CompanyStatuses)
-	fetchAndStore(client.CompanyWebsites)
-	fetchAndStore(client.Covers)
-	fetchAndStore(client.DateFormats)
-	fetchAndStore(client.EventLogos)
-	fetchAndStore(client.EventNetworks)
-	fetchAndStore(client.Events)
-	fetchAndStore(client.ExternalGames)
-	fetchAndStore(client.ExternalGameSources)
-	fetchAndStore(client.Franchises)
-	fetchAndStore(client.GameEngineLogos)
-	fetchAndStore(client.GameEngines)
-	fetchAndStore(client.GameLocalizations)
-	fetchAndStore(client.GameModes)
-	fetchAndStore(client.GameReleaseFormats)
-	fetchAndStore(client.GameStatuses)
-	fetchAndStore(client.GameTimeToBeats)
-	fetchAndStore(client.GameTypes)
-	fetchAndStore(client.GameVersionFeatures)
-	fetchAndStore(client.GameVersionFeatureValues)
-	fetchAndStore(client.GameVersions)
-	fetchAndStore(client.GameVideos)
-	fetchAndStore(client.Genres)
-	fetchAndStore(client.InvolvedCompanies)
-	fetchAndStore(client.Keywords)
-	fetchAndStore(client.Languages)
-	fetchAndStore(client.LanguageSupports)
-	fetchAndStore(client.LanguageSupportTypes)
-	fetchAndStore(client.MultiplayerModes)
-	fetchAndStore(client.NetworkTypes)
-	fetchAndStore(client.PlatformFamilies)
-	fetchAndStore(client.PlatformLogos)
-	fetchAndStore(client.Platforms)
-	fetchAndStore(client.PlatformTypes)
-	fetchAndStore(client.PlatformVersionCompanies)
-	fetchAndStore(client.PlatformVersionReleaseDates)
-	fetchAndStore(client.PlatformVersions)
-	fetchAndStore(client.PlatformWebsites)
-	fetchAndStore(client.PlayerPerspectives)
-	fetchAndStore(client.PopularityPrimitives)
-	fetchAndStore(client.PopularityTypes)
-	fetchAndStore(client.Regions)
-	fetchAndStore(client.ReleaseDateRegions)
-	fetchAndStore(client.ReleaseDates)
-	fetchAndStore(client.ReleaseDateStatuses)
-	fetchAndStore(client.Screenshots)
-	fetchAndStore(client.Themes)
-	fetchAndStore(client.Websites)
-	fetchAndStore(client.WebsiteTypes)
-
-	fetchAndStore(client.Games)
+// finishRun records the result of run and returns its exit code. The result
+// is recorded even if ctx was cancelled.
+func finishRun(ctx context.Context, s db.Store, run *model.Run, code int, summary string) int {
+	now := time.Now()
+	run.FinishedAt = &now
+	run.ExitCode = code
+	run.Summary = summary
+	if err := s.SaveRun(context.WithoutCancel(ctx), run); err != nil {
+		slog.Error("failed to save run", "command", run.Command, logging.Err(err))
+	}
+	return code
+}
+
+func newStore(ctx context.Context) db.Store {
+	switch config.C().Storage {
+	case "", "mongodb":
+		return db.GetInstance(ctx)
+	case "memory":
+		slog.Warn("using in-memory storage, nothing will be persisted")
+		return db.NewMemoryStore()
+	default:
+		slog.Error("unknown storage", "storage", config.C().Storage)
+		os.Exit(exitUsage)
+		return nil
+	}
 }
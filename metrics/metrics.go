@@ -0,0 +1,139 @@
+// Package metrics defines the Prometheus metrics of the collector, the
+// aggregator and the webhook server.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/v2/event"
+)
+
+const namespace = "igdb_database"
+
+var (
+	igdbRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "igdb_requests_total",
+		Help:      "Requests sent to IGDB by endpoint, operation and status.",
+	}, []string{"endpoint", "operation", "status"})
+	igdbRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "igdb_request_duration_seconds",
+		Help:      "Latency of the requests sent to IGDB.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"endpoint", "operation"})
+
+	mongoCommands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_commands_total",
+		Help:      "MongoDB commands by command name and status.",
+	}, []string{"command", "status"})
+	mongoCommandDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "Latency of the MongoDB commands.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"command"})
+
+	webhooksReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhooks_received_total",
+		Help:      "Webhook calls received from IGDB and stored in the queue.",
+	}, []string{"endpoint", "method"})
+	webhookLastReceived = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "webhook_last_received_timestamp_seconds",
+		Help:      "Time the last webhook call of an endpoint was received.",
+	}, []string{"endpoint"})
+	webhookEventsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_processed_total",
+		Help:      "Webhook events applied by the workers.",
+	}, []string{"endpoint", "method"})
+	webhookEventsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_failed_total",
+		Help:      "Failed attempts to apply webhook events, by whether the event is retried or dead lettered.",
+	}, []string{"endpoint", "method", "result"})
+
+	gamesAggregated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "games_aggregated_total",
+		Help:      "Games aggregated into game_details.",
+	})
+	aggregationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "aggregation_duration_seconds",
+		Help:      "Duration of aggregating a batch of games, including the relations fetched from IGDB.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 3, 10),
+	})
+	lastAggregation = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "last_aggregation_timestamp_seconds",
+		Help:      "Time games were last aggregated.",
+	})
+)
+
+// Handler serves the metrics of the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+func status(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// IgdbRequest records an IGDB request of endpoint that started at start.
+func IgdbRequest(endpoint string, operation string, start time.Time, err error) {
+	igdbRequests.WithLabelValues(endpoint, operation, status(err)).Inc()
+	igdbRequestDuration.WithLabelValues(endpoint, operation).Observe(time.Since(start).Seconds())
+}
+
+// MongoMonitor returns a command monitor recording every MongoDB command.
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			mongoCommands.WithLabelValues(e.CommandName, "ok").Inc()
+			mongoCommandDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			mongoCommands.WithLabelValues(e.CommandName, "error").Inc()
+			mongoCommandDuration.WithLabelValues(e.CommandName).Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+// WebhookReceived records a webhook call stored in the queue.
+func WebhookReceived(endpoint string, method string) {
+	webhooksReceived.WithLabelValues(endpoint, method).Inc()
+	webhookLastReceived.WithLabelValues(endpoint).SetToCurrentTime()
+}
+
+// WebhookProcessed records a webhook event applied by a worker.
+func WebhookProcessed(endpoint string, method string) {
+	webhookEventsProcessed.WithLabelValues(endpoint, method).Inc()
+}
+
+// WebhookFailed records a failed attempt to apply a webhook event.
+func WebhookFailed(endpoint string, method string, deadLettered bool) {
+	result := "retry"
+	if deadLettered {
+		result = "dead_letter"
+	}
+	webhookEventsFailed.WithLabelValues(endpoint, method, result).Inc()
+}
+
+// GamesAggregated records a batch of n games aggregated since start.
+func GamesAggregated(n int, start time.Time) {
+	gamesAggregated.Add(float64(n))
+	aggregationDuration.Observe(time.Since(start).Seconds())
+	lastAggregation.SetToCurrentTime()
+}
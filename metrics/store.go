@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"igdb-database/logging"
+	"igdb-database/model"
+	"log/slog"
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Store is the part of db.Store the collection metrics are read from.
+type Store interface {
+	EstimatedDocumentCount(ctx context.Context, e endpoint.Name) (int64, error)
+	CountGames(ctx context.Context) (int64, error)
+	GetSyncStates(ctx context.Context) ([]*model.SyncState, error)
+}
+
+// queueStore is implemented by stores with a webhook queue.
+type queueStore interface {
+	CountWebhookEvents(ctx context.Context) (int64, error)
+	CountDeadLetters(ctx context.Context) (int64, error)
+}
+
+// collectTimeout limits the queries of one scrape.
+const collectTimeout = 10 * time.Second
+
+var (
+	documentsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "documents"),
+		"Estimated number of documents per collection.",
+		[]string{"collection"}, nil,
+	)
+	syncStartedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sync_started_timestamp_seconds"),
+		"Time the last bulk fetch of an endpoint started.",
+		[]string{"endpoint"}, nil,
+	)
+	syncFinishedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sync_finished_timestamp_seconds"),
+		"Time the last bulk fetch of an endpoint finished, 0 while it is unfinished.",
+		[]string{"endpoint"}, nil,
+	)
+	syncFailedPagesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sync_failed_pages"),
+		"Pages of the last bulk fetch of an endpoint that failed.",
+		[]string{"endpoint"}, nil,
+	)
+	queueDepthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "webhook_queue_depth"),
+		"Webhook events waiting in the queue or being processed.",
+		nil, nil,
+	)
+	deadLettersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "webhook_dead_letters"),
+		"Webhook events that failed too often and wait for a replay.",
+		nil, nil,
+	)
+)
+
+// storeCollector reads the collection sizes and the sync state on every
+// scrape, so they are current even if another process wrote them.
+type storeCollector struct {
+	s Store
+}
+
+// RegisterStore adds the collection sizes, the sync state and, if s has a
+// webhook queue, the queue depth of s to the metrics.
+func RegisterStore(s Store) {
+	prometheus.MustRegister(&storeCollector{s: s})
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- documentsDesc
+	ch <- syncStartedDesc
+	ch <- syncFinishedDesc
+	ch <- syncFailedPagesDesc
+	if _, ok := c.s.(queueStore); ok {
+		ch <- queueDepthDesc
+		ch <- deadLettersDesc
+	}
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), collectTimeout)
+	defer cancel()
+
+	for _, e := range endpoint.AllNames {
+		if e == endpoint.EPWebhooks || e == endpoint.EPSearch {
+			continue
+		}
+		count, err := c.s.EstimatedDocumentCount(ctx, e)
+		if err != nil {
+			slog.Error("failed to collect metrics", logging.Err(err))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(documentsDesc, prometheus.GaugeValue, float64(count), string(e))
+	}
+	if count, err := c.s.CountGames(ctx); err != nil {
+		slog.Error("failed to collect metrics", logging.Err(err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(documentsDesc, prometheus.GaugeValue, float64(count), "game_details")
+	}
+
+	states, err := c.s.GetSyncStates(ctx)
+	if err != nil {
+		slog.Error("failed to collect metrics", logging.Err(err))
+	}
+	for _, state := range states {
+		name := string(state.Endpoint)
+		finished := 0.0
+		if state.IsFinished() {
+			finished = float64(state.FinishedAt.Unix())
+		}
+		ch <- prometheus.MustNewConstMetric(syncStartedDesc, prometheus.GaugeValue, float64(state.StartedAt.Unix()), name)
+		ch <- prometheus.MustNewConstMetric(syncFinishedDesc, prometheus.GaugeValue, finished, name)
+		ch <- prometheus.MustNewConstMetric(syncFailedPagesDesc, prometheus.GaugeValue, float64(len(state.FailedOffsets)), name)
+	}
+
+	q, ok := c.s.(queueStore)
+	if !ok {
+		return
+	}
+	if count, err := q.CountWebhookEvents(ctx); err != nil {
+		slog.Error("failed to collect metrics", logging.Err(err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count))
+	}
+	if count, err := q.CountDeadLetters(ctx); err != nil {
+		slog.Error("failed to collect metrics", logging.Err(err))
+	} else {
+		ch <- prometheus.MustNewConstMetric(deadLettersDesc, prometheus.GaugeValue, float64(count))
+	}
+}
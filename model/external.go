@@ -0,0 +1,19 @@
+package model
+
+// ExternalGameMatch maps the uid of an external game source, e.g. a Steam
+// app id, to its game. Game is only set if the game is aggregated.
+type ExternalGameMatch struct {
+	Uid            string `json:"uid"`
+	Source         string `json:"source"`
+	ExternalGameId uint64 `json:"external_game_id"`
+	GameId         uint64 `json:"game_id"`
+	Url            string `json:"url,omitempty"`
+	Game           *Game  `json:"game,omitempty"`
+}
+
+// ExternalLookupResult is the result of a batch lookup of external uids.
+type ExternalLookupResult struct {
+	Source  string               `json:"source"`
+	Matches []*ExternalGameMatch `json:"matches"`
+	Missing []string             `json:"missing"`
+}
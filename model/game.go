@@ -65,5 +65,48 @@ type Game struct {
 	GameStatus            *pb.GameStatus          `json:"game_status,omitempty"`
 	GameType              *pb.GameType            `json:"game_type,omitempty"`
 
-	AllNames []string `json:"all_names,omitempty"`
+	AllNames []string      `json:"all_names,omitempty"`
+	Related  *RelatedGames `json:"related,omitempty"`
+}
+
+// RelatedGames holds the summaries of the games referenced by the id fields
+// of Game with the same names. Games that are not stored have the id only.
+type RelatedGames struct {
+	Bundles              []*GameSummary `json:"bundles,omitempty"`
+	Dlcs                 []*GameSummary `json:"dlcs,omitempty"`
+	ExpandedGames        []*GameSummary `json:"expanded_games,omitempty"`
+	Expansions           []*GameSummary `json:"expansions,omitempty"`
+	Forks                []*GameSummary `json:"forks,omitempty"`
+	ParentGame           *GameSummary   `json:"parent_game,omitempty"`
+	Ports                []*GameSummary `json:"ports,omitempty"`
+	Remakes              []*GameSummary `json:"remakes,omitempty"`
+	Remasters            []*GameSummary `json:"remasters,omitempty"`
+	SimilarGames         []*GameSummary `json:"similar_games,omitempty"`
+	StandaloneExpansions []*GameSummary `json:"standalone_expansions,omitempty"`
+	VersionParent        *GameSummary   `json:"version_parent,omitempty"`
+}
+
+// GameFamily is the tree of games around a base game, e.g. its editions,
+// DLCs and remakes.
+type GameFamily struct {
+	// Id is the requested game, Root is the base game of its family.
+	Id   uint64          `json:"id"`
+	Root *GameFamilyNode `json:"root"`
+	// Truncated is set when the depth or size limit was reached, so games
+	// may be missing.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type GameFamilyNode struct {
+	*GameSummary
+	Versions             []*GameFamilyNode `json:"versions,omitempty"`
+	Dlcs                 []*GameFamilyNode `json:"dlcs,omitempty"`
+	Expansions           []*GameFamilyNode `json:"expansions,omitempty"`
+	StandaloneExpansions []*GameFamilyNode `json:"standalone_expansions,omitempty"`
+	Remakes              []*GameFamilyNode `json:"remakes,omitempty"`
+	Remasters            []*GameFamilyNode `json:"remasters,omitempty"`
+	Ports                []*GameFamilyNode `json:"ports,omitempty"`
+	// Children are the other games whose parent game this is, e.g.
+	// episodes, bundles or mods.
+	Children []*GameFamilyNode `json:"children,omitempty"`
 }
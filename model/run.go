@@ -0,0 +1,13 @@
+package model
+
+import "time"
+
+// Run is the result of the last run of a batch command like fetch or
+// aggregate. A run without FinishedAt is still running or was killed.
+type Run struct {
+	Command    string     `json:"command"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExitCode   int        `json:"exit_code"`
+	Summary    string     `json:"summary,omitempty"`
+}
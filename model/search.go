@@ -0,0 +1,45 @@
+package model
+
+import (
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+type GameSummary struct {
+	Id               uint64                 `json:"id,omitempty"`
+	Name             string                 `json:"name,omitempty"`
+	Slug             string                 `json:"slug,omitempty"`
+	CoverImageId     string                 `json:"cover_image_id,omitempty"`
+	FirstReleaseDate *timestamppb.Timestamp `json:"first_release_date,omitempty"`
+	GameType         string                 `json:"game_type,omitempty"`
+}
+
+func NewGameSummary(game *Game) *GameSummary {
+	res := &GameSummary{
+		Id:               game.Id,
+		Name:             game.Name,
+		Slug:             game.Slug,
+		FirstReleaseDate: game.FirstReleaseDate,
+	}
+	if game.Cover != nil {
+		res.CoverImageId = game.Cover.ImageId
+	}
+	if game.GameType != nil {
+		res.GameType = game.GameType.Type
+	}
+	return res
+}
+
+type SearchHit struct {
+	*GameSummary
+	MatchedName string  `json:"matched_name,omitempty"`
+	Score       float64 `json:"score"`
+}
+
+type SearchResult struct {
+	// Total is the number of ranked hits. Only the best 500 are ranked, so
+	// it is capped at 500 and is not the number of all matching games.
+	Total    int          `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"page_size"`
+	Hits     []*SearchHit `json:"hits"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+// SyncState records the progress of a bulk fetch of one endpoint so an
+// interrupted fetch can be resumed.
+type SyncState struct {
+	Endpoint         endpoint.Name `json:"endpoint"`
+	Total            uint64        `json:"total"`
+	PageSize         uint64        `json:"page_size"`
+	CompletedOffsets []uint64      `json:"completed_offsets"`
+	FailedOffsets    []uint64      `json:"failed_offsets"`
+	LastError        string        `json:"last_error,omitempty"`
+	StartedAt        time.Time     `json:"started_at"`
+	FinishedAt       *time.Time    `json:"finished_at,omitempty"`
+}
+
+func (s *SyncState) IsFinished() bool {
+	return s.FinishedAt != nil
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"github.com/bestnite/go-igdb/endpoint"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type WebhookMethod string
+
+const (
+	WebhookMethodUpsert WebhookMethod = "upsert"
+	WebhookMethodDelete WebhookMethod = "delete"
+)
+
+type WebhookEventStatus string
+
+const (
+	WebhookEventPending    WebhookEventStatus = "pending"
+	WebhookEventProcessing WebhookEventStatus = "processing"
+)
+
+// WebhookEvent is a received webhook call waiting in the queue. The queue
+// holds at most one event per entity, carrying the method of the latest call.
+type WebhookEvent struct {
+	MId           bson.ObjectID      `json:"_id,omitempty"`
+	Endpoint      endpoint.Name      `json:"endpoint"`
+	Method        WebhookMethod      `json:"method"`
+	EntityId      uint64             `json:"entity_id"`
+	Status        WebhookEventStatus `json:"status"`
+	Attempts      int                `json:"attempts"`
+	LastError     string             `json:"last_error,omitempty"`
+	NextAttemptAt time.Time          `json:"next_attempt_at"`
+	LockedUntil   time.Time          `json:"locked_until"`
+	CreatedAt     time.Time          `json:"created_at"`
+	FailedAt      *time.Time         `json:"failed_at,omitempty"`
+	// Version is incremented by every call queued for the entity, so a
+	// worker can tell that the event changed while it was processed.
+	Version int `json:"version"`
+	// RequestId correlates the log lines of the webhook call that queued
+	// the event with the ones of its processing.
+	RequestId string `json:"request_id,omitempty"`
+}
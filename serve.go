@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"igdb-database/collector"
+	"igdb-database/config"
+	"igdb-database/logging"
+	"log/slog"
+)
+
+func runServe(ctx context.Context, args []string) int {
+	fs := newFlagSet("serve", "serve [flags]")
+	register := fs.Bool("register", true, "register the webhooks with IGDB")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	if err := config.C().ValidateServer(); err != nil {
+		slog.Error("invalid config", logging.Err(err))
+		return exitUsage
+	}
+
+	client := newClient()
+	slog.Info("starting webhook server")
+	if err := collector.StartWebhookServer(ctx, newStore(ctx), client, *register); err != nil {
+		slog.Error("webhook server failed", logging.Err(err))
+		return exitError
+	}
+	return exitOK
+}
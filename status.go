@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"igdb-database/logging"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bestnite/go-igdb/endpoint"
+)
+
+func runStatus(ctx context.Context, args []string) int {
+	fs := newFlagSet("status", "status")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	endpoints := allEndpoints(newClient())
+	s := newStore(ctx)
+
+	states, err := s.GetSyncStates(ctx)
+	if err != nil {
+		slog.Error("failed to get sync states", logging.Err(err))
+		return exitError
+	}
+	stateByName := make(map[endpoint.Name]int, len(states))
+	for i, state := range states {
+		stateByName[state.Endpoint] = i
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ENDPOINT\tITEMS\tPAGES\tFAILED PAGES\tFETCH")
+	for _, e := range endpoints {
+		count, err := s.EstimatedDocumentCount(ctx, e.name)
+		if err != nil {
+			slog.Error("failed to count items", logging.KeyEndpoint, e.name, logging.Err(err))
+			return exitError
+		}
+		i, ok := stateByName[e.name]
+		if !ok {
+			fmt.Fprintf(w, "%s\t%d\t-\t-\tnever\n", e.name, count)
+			continue
+		}
+		state := states[i]
+		pages := (state.Total + state.PageSize - 1) / max(state.PageSize, 1)
+		fetch := "unfinished"
+		if state.IsFinished() {
+			fetch = "finished " + state.FinishedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d/%d\t%d\t%s\n", e.name, count, len(state.CompletedOffsets), pages, len(state.FailedOffsets), fetch)
+	}
+	if err := w.Flush(); err != nil {
+		slog.Error("failed to write status", logging.Err(err))
+		return exitError
+	}
+
+	games, err := s.CountGames(ctx)
+	if err != nil {
+		slog.Error("failed to count games", logging.Err(err))
+		return exitError
+	}
+	fmt.Printf("\naggregated games: %d\n", games)
+	runs, err := s.GetRuns(ctx)
+	if err != nil {
+		slog.Error("failed to get runs", logging.Err(err))
+		return exitError
+	}
+	for _, run := range runs {
+		result := "running"
+		if run.FinishedAt != nil {
+			result = fmt.Sprintf("exit %d at %s: %s", run.ExitCode, run.FinishedAt.Format("2006-01-02 15:04:05"), run.Summary)
+		}
+		fmt.Printf("last %s: started %s, %s\n", run.Command, run.StartedAt.Format("2006-01-02 15:04:05"), result)
+	}
+	events, err := s.CountWebhookEvents(ctx)
+	if err != nil {
+		slog.Error("failed to count webhook events", logging.Err(err))
+		return exitError
+	}
+	fmt.Printf("queued webhook events: %d\n", events)
+	return exitOK
+}
+
+func runVerify(ctx context.Context, args []string) int {
+	fs := newFlagSet("verify", "verify [endpoints...]")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	endpoints, err := selectEndpoints(allEndpoints(newClient()), fs.Args(), nil)
+	if err != nil {
+		slog.Error("invalid endpoints", logging.Err(err))
+		return exitUsage
+	}
+	s := newStore(ctx)
+
+	differences := 0
+	for _, e := range endpoints {
+		remote, err := e.count()
+		if err != nil {
+			slog.Error("failed to count items on igdb", logging.KeyEndpoint, e.name, logging.Err(err))
+			return exitError
+		}
+		local, err := s.CountDocuments(ctx, e.name)
+		if err != nil {
+			slog.Error("failed to count items", logging.KeyEndpoint, e.name, logging.Err(err))
+			return exitError
+		}
+		if uint64(local) != remote {
+			differences++
+			fmt.Printf("%s: %d local, %d on igdb\n", e.name, local, remote)
+		}
+
+		if e.name == endpoint.EPGames {
+			games, err := s.CountGames(ctx)
+			if err != nil {
+				slog.Error("failed to count games", logging.Err(err))
+				return exitError
+			}
+			if games != local {
+				differences++
+				fmt.Printf("game_details: %d aggregated, %d games\n", games, local)
+			}
+		}
+	}
+
+	if differences > 0 {
+		fmt.Printf("%d differences found\n", differences)
+		return exitIncomplete
+	}
+	fmt.Printf("%d endpoints verified\n", len(endpoints))
+	return exitOK
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"igdb-database/collector"
+	"igdb-database/config"
+	"igdb-database/logging"
+	"log/slog"
+	"os"
+	"strconv"
+)
+
+func runWebhooks(ctx context.Context, args []string) int {
+	fs := newFlagSet("webhooks", "webhooks list | register | unregister [-all] [ids...]")
+	all := fs.Bool("all", false, "unregister all webhooks")
+	if len(args) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	action := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		return exitUsage
+	}
+
+	client := newClient()
+	switch action {
+	case "list":
+		webhooks, err := client.Webhooks.List()
+		if err != nil {
+			slog.Error("failed to list webhooks", logging.Err(err))
+			return exitError
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(webhooks); err != nil {
+			slog.Error("failed to write webhooks", logging.Err(err))
+			return exitError
+		}
+
+	case "register":
+		if err := config.C().ValidateServer(); err != nil {
+			slog.Error("invalid config", logging.Err(err))
+			return exitUsage
+		}
+		if err := collector.RegisterWebhooks(ctx, client); err != nil {
+			slog.Error("failed to register webhooks", logging.Err(err))
+			return exitError
+		}
+
+	case "unregister":
+		ids := []uint64{}
+		for _, arg := range fs.Args() {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil {
+				slog.Error("invalid webhook id", "id", arg)
+				return exitUsage
+			}
+			ids = append(ids, id)
+		}
+		if *all {
+			webhooks, err := client.Webhooks.List()
+			if err != nil {
+				slog.Error("failed to list webhooks", logging.Err(err))
+				return exitError
+			}
+			for _, webhook := range webhooks {
+				ids = append(ids, webhook.Id)
+			}
+		}
+		if len(ids) == 0 {
+			fs.Usage()
+			return exitUsage
+		}
+		for _, id := range ids {
+			if err := client.Webhooks.Unregister(id); err != nil {
+				slog.Error("failed to unregister webhook", "id", id, logging.Err(err))
+				return exitError
+			}
+			slog.Info("webhook unregistered", "id", id)
+		}
+
+	default:
+		fs.Usage()
+		return exitUsage
+	}
+	return exitOK
+}